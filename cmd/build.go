@@ -21,41 +21,80 @@
 package cmd
 
 import (
-	"fmt"
+	"compress/gzip"
+	"encoding/xml"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
-	"sort"
-	"strconv"
 	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
-	"github.com/joeguo/sitemap"
-	"github.com/russross/blackfriday"
 	"github.com/spf13/cobra"
 )
 
 type (
 	config struct {
-		Domain string
-		Theme  string
-		Https  string
-		Pretty string
+		Domain          string
+		Theme           string
+		Https           string
+		Pretty          string
+		DefaultLanguage string `toml:"defaultlanguage"`
+		Languages       []language
+		Redirects       redirects
+		Search          search
+		Markdown        markdownOptions
+		Highlight       highlightOptions
+		Robots          string
+		API             api
+		Params          map[string]interface{}
+		Menus           []menuLink `toml:"menu"`
+	}
+
+	language struct {
+		Code     string
+		Name     string
+		Hreflang string
 	}
 
 	pageConfig struct {
+		Aliases    []string  `toml:"aliases"`
+		Search     string    `toml:"search"`
+		Draft      bool      `toml:"draft"`
+		Publish    time.Time `toml:"publish"`
+		Expire     time.Time `toml:"expire"`
 		Meta       meta
 		Navigation navigation
 		Design     design
 	}
 
 	pageContent struct {
-		Path    string
-		Content string
+		Source      string
+		Path        string
+		Content     string
+		Language    string
+		Key         string
+		Link        string
+		Aliases     []string
+		Title       string
+		Description string
+		Search      string
+		Template    string
+		Meta        meta
+		Navigation  navigation
+		Elements    []pageElement
+	}
+
+	// An element parsed from a markdown file, Content is as written and HTML is ready for the template
+	pageElement struct {
+		Type     string
+		Name     string
+		Content  string
+		HTML     string
+		Headings []heading
 	}
 
 	// TOML parsing structs
@@ -67,8 +106,10 @@ type (
 	}
 
 	navigation struct {
-		Text  string
-		Order string
+		Text   string
+		Order  string
+		Menus  []string `toml:"menus"`
+		Hidden bool     `toml:"hidden"`
 	}
 
 	design struct {
@@ -77,13 +118,37 @@ type (
 
 	// Navigation building
 	navigationContent struct {
-		Text        string
-		Order       string
-		Link        string
-		NaturalLink string
+		Text     string
+		Order    int
+		Link     string
+		Section  string
+		Language string
+		Menus    []string
 	}
 
 	navigationItems []navigationContent
+
+	// Sitemap building
+	sitemapURLSet struct {
+		XMLName xml.Name     `xml:"urlset"`
+		Xmlns   string       `xml:"xmlns,attr"`
+		Xhtml   string       `xml:"xmlns:xhtml,attr,omitempty"`
+		URLs    []sitemapURL `xml:"url"`
+	}
+
+	sitemapURL struct {
+		Loc        string             `xml:"loc"`
+		Alternates []sitemapAlternate `xml:"xhtml:link"`
+		LastMod    string             `xml:"lastmod"`
+		Changefreq string             `xml:"changefreq"`
+		Priority   string             `xml:"priority"`
+	}
+
+	sitemapAlternate struct {
+		Rel      string `xml:"rel,attr"`
+		Hreflang string `xml:"hreflang,attr"`
+		Href     string `xml:"href,attr"`
+	}
 )
 
 var (
@@ -93,10 +158,15 @@ var (
 	conf           config
 	pageConf       pageConfig
 	partialsOutput map[string]string
-	pages          []pageContent
-	siteMap        []string
-	nav            []string
-	navElements    navigationItems
+	// Partials are rendered again for pages which override their elements
+	partialsElements map[string][]pageElement
+	partialsTemplate map[string]string
+	pages            []pageContent
+	navElements      navigationItems
+	themeDirs        []string
+	buildDrafts      bool
+	buildFuture      bool
+	buildExpired     bool
 )
 
 // Implement sort interface on navigationItems
@@ -104,18 +174,25 @@ func (slice navigationItems) Len() int {
 	return len(slice)
 }
 
+// Items are ordered by their order, then title and then path so builds are repeatable
 func (slice navigationItems) Less(i, j int) bool {
-	first, _ := strconv.Atoi(slice[i].Order)
-	second, _ := strconv.Atoi(slice[j].Order)
-	return first < second
+	if slice[i].Order != slice[j].Order {
+		return slice[i].Order < slice[j].Order
+	}
+	if slice[i].Text != slice[j].Text {
+		return slice[i].Text < slice[j].Text
+	}
+	return slice[i].Link < slice[j].Link
 }
 
 func (slice navigationItems) Swap(i, j int) {
 	slice[i], slice[j] = slice[j], slice[i]
 }
 
-func processPageFile(page string, dest string) {
-	var output, writeEl, navEl, navNatEl, sitemapEl, el string
+// Renders a page file with its template, returning the output and the page's elements. Pages
+// without front matter, or which aren't published, are not rendered
+func renderPage(page string) (string, []pageElement, bool) {
+	var output string
 
 	// Get the template from file
 	markdown, err := ioutil.ReadFile(page)
@@ -128,13 +205,19 @@ func processPageFile(page string, dest string) {
 	tomlSection := markdownToml.FindStringSubmatch(string(markdown))
 
 	if len(tomlSection) > 1 {
-		// Read TOML
+		// Read TOML, resetting first so values don't carry over from the previous page
+		pageConf = pageConfig{}
 		if _, err := toml.Decode(tomlSection[1], &pageConf); err != nil {
 			log.Fatal(err)
 		}
 
-		// Read template from theme
-		pageTemplate := relPath + projectDir + string(filepath.Separator) + "theme" + string(filepath.Separator) + conf.Theme + string(filepath.Separator) + pageConf.Design.Template + ".html"
+		// Drafts and pages outside their publishing window are left out, unless previewing
+		if !pagePublished(pageConf) {
+			return "", nil, false
+		}
+
+		// Read template from theme, or the theme it inherits from
+		pageTemplate, _ := findThemeFile(themeDirs, pageConf.Design.Template+".html")
 
 		template, err := ioutil.ReadFile(pageTemplate)
 		if err != nil {
@@ -148,68 +231,114 @@ func processPageFile(page string, dest string) {
 		output = processMeta(string(markdown), output)
 
 		// Merge Elements
-		output = processElements(string(markdown), output)
-
-		// Merge Partials (we have a map of these)
-		output = processPartials(output)
+		elements := parseElements(string(markdown))
+		own, overrides := partialOverrides(elements)
+		output = mergeElements(own, output)
 
-		// Logic is going to branch here, depending on whether pretty URLs are in use
+		// Table of contents, from the headings in the page's elements
+		output = processToc(output, own)
 
-		switch conf.Pretty {
-		case "on":
-			// On, directory for page name and file is index.html
-			splitDest := strings.Replace(strings.Split(dest, "compiled")[1], string(filepath.Separator), "", 1)
-			splitDest2 := strings.Split(splitDest, string(filepath.Separator))
+		// Merge Partials (we have a map of these), with any the page overrides
+		output = processPartials(output, overrides)
 
-			if len(splitDest2) > 1 {
-				el = splitDest2[1]
-			} else {
-				el = splitDest2[0]
-			}
-
-			writeEl = strings.Replace(dest, el, "", -1)
-			var newDir string
-			if el != "index.md" {
-				newDir = strings.Replace(el, ".md", "", -1)
-				os.MkdirAll(writeEl+string(filepath.Separator)+newDir, 0755)
-			}
-			if newDir != "" {
-				writeEl = writeEl + newDir + string(filepath.Separator) + "index.html"
-			} else {
-				writeEl = writeEl + "index.html"
-			}
+		return output, elements, true
+	}
+	return "", nil, false
+}
 
-			navEl = strings.Replace(strings.Split(writeEl, "compiled")[1], "index.html", "", -1)
-			navNatEl = strings.Replace(strings.Split(strings.Replace(dest, ".md", ".html", -1), "compiled")[1], "index.html", "", -1)
-			sitemapEl = conf.Domain + navEl
+func processPageFile(page string, dest string) {
+	output, elements, ok := renderPage(page)
+	if !ok {
+		return
+	}
 
-		default:
-			// We should have conf.Pretty="off" but set as default
-			writeEl = strings.Replace(dest, ".md", ".html", -1)
-			navEl = strings.Replace(strings.Split(writeEl, "compiled")[1], "index.html", "", -1)
-			navNatEl = strings.Replace(strings.Split(writeEl, "compiled")[1], "index.html", "", -1)
-			sitemapEl = conf.Domain + navEl
-		}
+	// Work out the language and the language neutral key of the page, from its path within 'compiled'
+	compiledPath := relPath + projectDir + string(filepath.Separator) + "compiled"
+	rel, err := filepath.Rel(compiledPath, dest)
+	if err != nil {
+		log.Fatal("Error page could not be built")
+	}
+	lang, key := pageLanguage(rel)
 
-		// Add to sitemap
-		siteMap = append(siteMap, sitemapEl)
+	// Destination and link
+	writeRel, navEl := pagePaths(languagePrefix(lang) + key)
+	writeEl := compiledPath + string(filepath.Separator) + writeRel
 
-		// Add to nav
+	// Add to nav, link text falls back to the page title and pages with neither are left out
+	text := pageConf.Navigation.Text
+	if text == "" {
+		text = pageConf.Meta.Title
+	}
+	if text != "" && !pageConf.Navigation.Hidden {
 		nav := navigationContent{
-			Text:        pageConf.Navigation.Text,
-			Order:       pageConf.Navigation.Order,
-			Link:        navEl,
-			NaturalLink: navNatEl,
+			Text:     text,
+			Order:    navOrder(pageConf.Navigation.Order, page),
+			Link:     navEl,
+			Section:  navSection(key),
+			Language: lang,
+			Menus:    pageMenus(pageConf.Navigation),
 		}
 		navElements = append(navElements, nav)
+	}
+
+	// Add to pages slice, the sitemap is built from this too
+	p := pageContent{
+		Source:      page,
+		Path:        writeEl,
+		Content:     output,
+		Language:    lang,
+		Key:         key,
+		Link:        navEl,
+		Aliases:     pageConf.Aliases,
+		Title:       pageConf.Meta.Title,
+		Description: pageConf.Meta.Description,
+		Search:      pageConf.Search,
+		Template:    pageConf.Design.Template,
+		Meta:        pageConf.Meta,
+		Navigation:  pageConf.Navigation,
+		Elements:    elements,
+	}
+	pages = append(pages, p)
+}
+
+// Checks whether a page should be built, given its draft status and publish and expire dates
+func pagePublished(pc pageConfig) bool {
+	now := time.Now()
+	if pc.Draft && !buildDrafts {
+		return false
+	}
+	if !pc.Publish.IsZero() && pc.Publish.After(now) && !buildFuture {
+		return false
+	}
+	if !pc.Expire.IsZero() && !pc.Expire.After(now) && !buildExpired {
+		return false
+	}
+	return true
+}
 
-		// Add tp pages slice
-		p := pageContent{
-			Path:    writeEl,
-			Content: output,
+// Returns the path to write a page to, relative to 'compiled', and the link to it. Logic
+// branches here, depending on whether pretty URLs are in use
+func pagePaths(rel string) (string, string) {
+	var writeRel, link string
+	base := strings.TrimSuffix(rel, ".md")
+
+	switch conf.Pretty {
+	case "on":
+		// On, directory for page name and file is index.html
+		if filepath.Base(base) == "index" {
+			writeRel = base + ".html"
+			link = string(filepath.Separator) + strings.TrimSuffix(base, "index")
+		} else {
+			writeRel = base + string(filepath.Separator) + "index.html"
+			link = string(filepath.Separator) + base + string(filepath.Separator)
 		}
-		pages = append(pages, p)
+
+	default:
+		// We should have conf.Pretty="off" but set as default
+		writeRel = base + ".html"
+		link = string(filepath.Separator) + strings.TrimSuffix(writeRel, "index.html")
 	}
+	return writeRel, link
 }
 
 func processFile(source string, dest string, contentType string) (err error) {
@@ -217,20 +346,16 @@ func processFile(source string, dest string, contentType string) (err error) {
 	switch contentType {
 	case "page":
 		processPageFile(source, dest)
+	case "error":
+		processErrorFile(source, dest)
 	}
 	return
 }
 
 func processDir(source string, dest string, contentType string) (err error) {
-	// Dest properties of source dir
-	sourceinfo, err := os.Stat(source)
-	if err != nil {
-		return err
-
-	}
-
-	// Create dest dir
-	err = os.MkdirAll(dest, sourceinfo.Mode())
+	// Check source dir, dest dirs are created as pages are written as
+	// language and pretty URLs mean they don't mirror the source
+	_, err = os.Stat(source)
 	if err != nil {
 		return err
 	}
@@ -245,19 +370,36 @@ func processDir(source string, dest string, contentType string) (err error) {
 			// Create sub-directories - recursively
 			err = processDir(sourcefilepointer, destinationfilepointer, contentType)
 			if err != nil {
-				fmt.Println(err)
+				log.Println(err)
 			}
 		} else {
 			// Perform copy
 			err = processFile(sourcefilepointer, destinationfilepointer, contentType)
 			if err != nil {
-				fmt.Println(err)
+				log.Println(err)
 			}
 		}
 	}
 	return
 }
 
+// Parses the site's partials, pages and error pages as a build does, without writing anything
+func parseSite() error {
+	sitePath := relPath + projectDir + string(filepath.Separator)
+	buildPartials()
+
+	err := processDir(sitePath+"pages", sitePath+"compiled", "page")
+	if err != nil {
+		return err
+	}
+
+	// Error pages are optional
+	if dirExist(sitePath + "errors") {
+		return processDir(sitePath+"errors", sitePath+"compiled", "error")
+	}
+	return nil
+}
+
 func reflectField(pageConf *pageConfig, field string) string {
 	r := reflect.ValueOf(pageConf.Meta)
 	f := reflect.Indirect(r).FieldByName(field)
@@ -287,10 +429,15 @@ func processMeta(markdown string, template string) string {
 	return output
 }
 
-func processPartials(template string) string {
-	var output string
+func processPartials(template string, overrides map[string][]pageElement) string {
+	return expandPartials(template, overrides, []string{})
+}
+
+// Replaces partial tokens, recursively as partials may include other partials. The stack holds
+// the partials currently being expanded, so a partial including itself can be reported
+func expandPartials(template string, overrides map[string][]pageElement, stack []string) string {
 	// Parse partial tags in template with regex
-	var templateToken = regexp.MustCompile(`\[\[partial\sname\=\"([a-zA-Z0-9_-]*)\"\s*]]`)
+	var templateToken = regexp.MustCompile(`\[\[partial\sname\=\"([a-zA-Z0-9_/-]*)\"\s*]]`)
 	templateTokens := templateToken.FindAllStringSubmatch(string(template), -1)
 
 	// We have a map loaded with all processed partials
@@ -298,29 +445,71 @@ func processPartials(template string) string {
 	for i := range templateTokens {
 		token := templateTokens[i][1]
 
-		if partialsOutput[token] != "" {
-			// We have a processed partial stored, do find replace
-			replace := "[[partial name=\"" + token + "\"]]"
-			template = strings.Replace(template, replace, partialsOutput[token], -1)
+		if partialsOutput[token] == "" {
+			continue
+		}
+		for _, name := range stack {
+			if name == token {
+				log.Fatal("Error partials include each other: " + strings.Join(append(stack, token), " -> "))
+			}
+		}
+
+		// Use the processed partial stored, unless the page overrides some of its elements
+		output := partialsOutput[token]
+		if len(overrides[token]) > 0 {
+			output = mergeElements(overrideElements(partialsElements[token], overrides[token]), partialsTemplate[token])
 		}
+		output = expandPartials(output, overrides, append(stack, token))
+
+		// Do find replace
+		template = strings.Replace(template, templateTokens[i][0], output, -1)
 	}
-	output = template
 	// Return a merged string
-	return output
+	return template
 }
 
-func processElements(markdown string, template string) string {
-	// THis is our regex \*\*\*\s([a-zA-Z0-9]*)\s.*\n([\d\D][^\*]*)\*\*\*  (needs g modifier) to pick out the name and markdown from the mark down files
-	// Merge the files!!
+// Page elements named partial.element override that element of the partial on that page. Returns
+// the page's own elements and the overrides, grouped by partial
+func partialOverrides(elements []pageElement) ([]pageElement, map[string][]pageElement) {
+	var own []pageElement
+	overrides := make(map[string][]pageElement)
+	for _, el := range elements {
+		i := strings.LastIndex(el.Name, ".")
+		if i == -1 {
+			own = append(own, el)
+			continue
+		}
+		partial := el.Name[:i]
+		el.Name = el.Name[i+1:]
+		overrides[partial] = append(overrides[partial], el)
+	}
+	return own, overrides
+}
 
-	var output string
+// Replaces a partial's elements with a page's overrides of them
+func overrideElements(elements []pageElement, overrides []pageElement) []pageElement {
+	var merged []pageElement
+	for _, el := range elements {
+		for _, override := range overrides {
+			if override.Name == el.Name {
+				el = override
+			}
+		}
+		merged = append(merged, el)
+	}
+	return merged
+}
 
-	// Parse element tags in template with regex
-	var templateToken = regexp.MustCompile(`\[\[element\stype\=\"([a-zA-Z0-9]*)\"\sname\=\"([a-zA-Z0-9_-]*)\"\sdescription\=\"(.*)"]]`)
-	templateTokens := templateToken.FindAllStringSubmatch(string(template), -1)
+// Parses the elements from a markdown file, rendering html elements
+func parseElements(markdown string) []pageElement {
+	// THis is our regex \*\*\*\s([a-zA-Z0-9]*)\s.*\n([\d\D][^\*]*)\*\*\*  (needs g modifier) to pick out the name and markdown from the mark down files
+	var elements []pageElement
+
+	// Heading ids must be unique across all elements
+	ids := make(map[string]bool)
 
 	// Parse element tags in markdown file with regex
-	var markdownToken = regexp.MustCompile(`\*\*\*([a-zA-Z0-9]*)\*\*\*\s([a-zA-Z0-9_-]*)\s.*\n([\d\D][^\*]*)\*\*\*`)
+	var markdownToken = regexp.MustCompile(`\*\*\*([a-zA-Z0-9]*)\*\*\*\s([a-zA-Z0-9_./-]*)\s.*\n([\d\D][^\*]*)\*\*\*`)
 	markdownTokens := markdownToken.FindAllStringSubmatch(string(markdown), -1)
 
 	// Range over all the markdown tokens
@@ -330,60 +519,91 @@ func processElements(markdown string, template string) string {
 		tokenContent := markdownTokens[i][3]
 		var htmlContent string
 
+		// Site parameters are substituted before any markdown conversion
+		source := processSiteParams(tokenContent)
+
 		// Process Markdown content ready for inclusion
 		if ttype == "text" {
 			// This should be output in raw form and not processed by markdown conversion
-			htmlContent = string(tokenContent)
+			htmlContent = string(source)
 		} else {
-			// Process the markdown
-			htmlContent = string(blackfriday.MarkdownCommon([]byte(tokenContent)))
+			// Expand shortcodes then process the markdown
+			htmlContent = string(mdRenderer.Render([]byte(expandShortcodes(source))))
 		}
 		htmlContent = string(strings.Trim(htmlContent, "\n\t "))
-		if token == strings.ToLower(templateTokens[i][2]) {
-			// We have a match
-			description := templateTokens[i][3]
-			// What to replace
-			replace := "[[element type=\"" + ttype + "\" name=\"" + token + "\" description=\"" + description + "\"]]"
-			// Replace
-			template = strings.Replace(template, replace, htmlContent, -1)
+
+		// Anchor headings, for linking and tables of contents
+		var headings []heading
+		if ttype != "text" {
+			htmlContent, headings = anchorHeadings(htmlContent, ids)
+		}
+
+		elements = append(elements, pageElement{
+			Type:     ttype,
+			Name:     token,
+			Content:  tokenContent,
+			HTML:     htmlContent,
+			Headings: headings,
+		})
+	}
+	return elements
+}
+
+// Merges parsed elements into a template
+func mergeElements(elements []pageElement, template string) string {
+	// Parse element tags in template with regex
+	var templateToken = regexp.MustCompile(`\[\[element\stype\=\"([a-zA-Z0-9]*)\"\sname\=\"([a-zA-Z0-9_-]*)\"\sdescription\=\"([^"]*)"]]`)
+	templateTokens := templateToken.FindAllStringSubmatch(string(template), -1)
+
+	for i := range elements {
+		for j := range templateTokens {
+			if elements[i].Type == strings.ToLower(templateTokens[j][1]) && elements[i].Name == strings.ToLower(templateTokens[j][2]) {
+				// We have a match, replace
+				template = strings.Replace(template, templateTokens[j][0], elements[i].HTML, -1)
+			}
 		}
 	}
-	output = template
 
 	// Return a merged string
-	return output
+	return template
 }
 
 func buildPartials() {
 	// Get partials
 	partialsMarkdown := make(map[string]string)
-	partialsTemplate := make(map[string]string)
-	partialsOutput = make(map[string]string) // Package namespace
+	partialsTemplate = make(map[string]string) // Package namespace
+	partialsElements = make(map[string][]pageElement)
+	partialsOutput = make(map[string]string)
 
 	partialsPath := relPath + projectDir + string(filepath.Separator) + "partials"
 	if dirExist(partialsPath) {
 
 		err := filepath.Walk(partialsPath, func(path string, f os.FileInfo, _ error) error {
 			if !f.IsDir() {
-				filename := strings.ToLower(strings.Split(f.Name(), ".")[0])
-				extension := strings.ToLower(strings.Split(f.Name(), ".")[1])
-				if extension == "md" {
+				// Partials may be in subdirectories, which become part of the name
+				rel, err := filepath.Rel(partialsPath, path)
+				if err != nil {
+					return err
+				}
+				filename := strings.ToLower(strings.TrimSuffix(rel, filepath.Ext(rel)))
+				extension := strings.ToLower(filepath.Ext(rel))
+				if extension == ".md" && !isNavPartial(filename) {
 					// Get the markdown file
-					md, err := ioutil.ReadFile(partialsPath + string(filepath.Separator) + f.Name())
+					md, err := ioutil.ReadFile(path)
 					if err != nil {
 						log.Fatal("Error reading a partial markdown file")
 					}
 
-					tmp, err := ioutil.ReadFile(relPath + projectDir + string(filepath.Separator) + "theme" + string(filepath.Separator) + conf.Theme +
-						string(filepath.Separator) + "partials" + string(filepath.Separator) + filename + ".html")
-
+					partialTemplate, _ := findThemeFile(themeDirs, "partials"+string(filepath.Separator)+filename+".html")
+					tmp, err := ioutil.ReadFile(partialTemplate)
 					if err != nil {
 						log.Fatal("Error reading a partial template file")
 					}
 
 					// Store to our maps
-					partialsMarkdown[filename] = string(md)
-					partialsTemplate[filename] = strings.Trim(string(tmp), "\t\n ")
+					name := strings.Replace(filename, string(filepath.Separator), "/", -1)
+					partialsMarkdown[name] = string(md)
+					partialsTemplate[name] = strings.Trim(string(tmp), "\t\n ")
 				}
 			}
 			return nil
@@ -393,44 +613,43 @@ func buildPartials() {
 			log.Fatal("Error unable to build partials")
 		}
 
-		// Range over one of the maps, pass name and both the markdown and template as args to processPartial()
+		// Range over one of the maps, merge the elements of each markdown into its template
 		for k := range partialsMarkdown {
-			partialsOutput[k] = processElements(partialsMarkdown[k], partialsTemplate[k])
+			partialsElements[k] = parseElements(partialsMarkdown[k])
+			partialsOutput[k] = mergeElements(partialsElements[k], partialsTemplate[k])
 		}
 	}
 }
 
 func copyThemeAssets() {
-	err := copyDir(relPath+projectDir+string(filepath.Separator)+"theme"+string(filepath.Separator)+conf.Theme, relPath+projectDir+string(filepath.Separator)+"compiled")
-	if err != nil {
-		log.Fatal("Error could not build theme assets")
+	// Parent themes first, so child theme assets replace them
+	for i := len(themeDirs) - 1; i >= 0; i-- {
+		err := copyDir(themeDirs[i], relPath+projectDir+string(filepath.Separator)+"compiled")
+		if err != nil {
+			log.Fatal("Error could not build theme assets")
+		}
 	}
 
-	// Remove partials
+	// Remove partials, all are templates
 	partialsPath := relPath + projectDir + string(filepath.Separator) + "compiled" + string(filepath.Separator) + "partials"
-	if dirExist(partialsPath) {
+	err := os.RemoveAll(partialsPath)
+	if err != nil {
+		log.Fatal("Error could not build theme assets")
+	}
 
-		err = filepath.Walk(partialsPath, func(path string, f os.FileInfo, _ error) error {
-			// Remove html templates
-			if !f.IsDir() {
-				if strings.ToLower(strings.Split(f.Name(), ".")[1]) == "html" {
-					_ = os.Remove(partialsPath + string(filepath.Separator) + f.Name())
-				}
-			}
-			return nil
-		})
+	// Remove shortcodes, also templates
+	err = os.RemoveAll(relPath + projectDir + string(filepath.Separator) + "compiled" + string(filepath.Separator) + "shortcodes")
+	if err != nil {
+		log.Fatal("Error could not build theme assets")
 	}
 
-	// Remove
+	// Remove templates and the theme manifest
 	err = filepath.Walk(relPath+projectDir+string(filepath.Separator)+"compiled", func(path string, f os.FileInfo, _ error) error {
 		// Remove html templates
 		if !f.IsDir() {
-			if strings.ToLower(strings.Split(f.Name(), ".")[1]) == "html" {
+			if strings.ToLower(filepath.Ext(f.Name())) == ".html" || f.Name() == "theme.toml" {
 				_ = os.Remove(relPath + projectDir + string(filepath.Separator) + "compiled" + string(filepath.Separator) + f.Name())
 			}
-		} else if f.Name() == "partials" {
-			// Remove partials folder
-			_ = os.Remove(relPath + projectDir + string(filepath.Separator) + "compiled" + string(filepath.Separator) + f.Name())
 		}
 		return nil
 	})
@@ -440,79 +659,51 @@ func copyThemeAssets() {
 	}
 }
 
-func writePages(nav string) {
+func writePages(navs map[string][]*navigationNode) {
 	// We have a slice struct of pages (package global) with all the info we need
 
 	// We need to add our nav, we've parsed all the pages and built it, so this is first opportunity
-	// the token to replace is [[navigation]]
+	// the token to replace is [[navigation]]. Each menu and language has its own navigation
 
 	// We then need to write the pages to their correct location in the compiled directory
 	for i := range pages {
-		content := pages[i].Content
-		dest := pages[i].Path
-
-		// Do replacement of [[navigation]]
-		content = strings.Replace(content, "[[navigation]]", nav, -1)
-
-		// Write page
-		err := ioutil.WriteFile(dest, []byte(content), 0755)
-		if err != nil {
-			log.Fatal("Error unable to write a static file")
-		}
+		writePage(pages[i], navs)
 	}
 
+	// Error pages are written the same way, they just aren't in navigation, sitemap or search
+	for i := range errorPages {
+		writePage(errorPages[i], navs)
+	}
 }
 
-func makeNav() string {
-	html := "<ul>\n"
-	// Sort elements
-	sort.Sort(navElements)
-
-	var curLevel, prevLevel int
-
-	prevLevel = 1
-	for i := range navElements {
-		// Need to reorder based on order struct properties
-		text := navElements[i].Text
-		link := strings.Replace(navElements[i].Link, string(filepath.Separator), "/", -1)
-		naturalLink := strings.Replace(navElements[i].NaturalLink, string(filepath.Separator), "/", -1)
+func writePage(page pageContent, navs map[string][]*navigationNode) {
+	content := page.Content
+	dest := page.Path
 
-		linkElements := strings.Split(naturalLink, "/")
-		elementsCount := len(linkElements)
+	// Do replacement of [[navigation]] and named menus
+	content = processNavigation(content, page, navs)
 
-		if elementsCount == 3 {
-			curLevel = 2
-		} else {
-			curLevel = 1
-		}
+	// Language tokens, need all pages parsed to find translations
+	content = processLanguages(page, content)
 
-		if curLevel == 1 && prevLevel == 2 {
-			html += "\t\t</ul>\n\t</li>\n"
-			html += "\t<li><a href=\"" + link + "\">" + text + "</a></li>\n"
-		}
+	// Search token
+	content = processSearch(content)
 
-		if curLevel == 2 && prevLevel == 1 {
+	// Environment token
+	content = processEnvironment(content)
 
-			html += "\t<li><a href=\"" + link + "\">" + text + "</a>\n"
-			html += "\t\t<ul>\n"
+	// Site parameter tokens left in templates, partials and meta
+	content = processSiteParams(content)
 
-		}
-		if curLevel == 1 && prevLevel == 1 {
-			html += "\t<li><a href=\"" + link + "\">" + text + "</a></li>\n"
-		}
-		if curLevel == 2 && prevLevel == 2 {
-			html += "\t\t\t<li><a href=\"" + link + "\">" + text + "</a></li>\n"
-		}
-		prevLevel = curLevel
+	// Write page, creating its directory as language and pretty URLs mean they don't mirror the source
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		log.Fatal("Error unable to write a static file")
 	}
-
-	if curLevel == 2 && prevLevel == 2 {
-		html += "\t\t</ul>\n\t</li>\n"
+	err = ioutil.WriteFile(dest, []byte(content), 0755)
+	if err != nil {
+		log.Fatal("Error unable to write a static file")
 	}
-
-	html += "</ul>"
-
-	return html
 }
 
 func setRelPathProjDir() {
@@ -522,6 +713,19 @@ func setRelPathProjDir() {
 		log.Fatal("Error could not establish project directory")
 	}
 
+	// Within a site? Walk up to the directory with its config.toml
+	if project == "" {
+		siteDir := dir
+		for levels := 0; siteDir != filepath.Dir(siteDir); levels++ {
+			if dirExist(siteDir + string(filepath.Separator) + "config.toml") {
+				projectDir = filepath.Base(siteDir)
+				relPath = strings.Repeat(".."+string(filepath.Separator), levels+1)
+				return
+			}
+			siteDir = filepath.Dir(siteDir)
+		}
+	}
+
 	dirs := strings.Split(dir, string(filepath.Separator))
 	lastDir := dirs[len(dirs)-1]
 
@@ -559,7 +763,8 @@ func setRelPathProjDir() {
 	}
 }
 
-func buildProject() error {
+// Establishes the project directory and reads its config.toml
+func loadConfig() {
 	// Establish target directory based on project, check to ensure 'config.toml' exists
 	setRelPathProjDir()
 
@@ -572,14 +777,33 @@ func buildProject() error {
 	}
 
 	// Read toml config file to establish root domain and theme in use
-	tomlData, err := ioutil.ReadFile(relPath + projectDir + string(filepath.Separator) + "config.toml")
-	if err != nil {
-		log.Fatal("Error config.toml could not be read")
+	decodeConfig(relPath + projectDir + string(filepath.Separator) + "config.toml")
+
+	// Environment config overrides, e.g. config.staging.toml
+	loadEnvironmentConfig()
+
+	// Environment variables referenced in config values
+	interpolateConfig(reflect.ValueOf(&conf).Elem())
+
+	// Markdown renderer and extensions chosen
+	mdRenderer = newMarkdownRenderer(conf.Markdown)
+	if optionOn(conf.Highlight.Enabled, false) {
+		mdRenderer = highlightRenderer{renderer: mdRenderer, options: conf.Highlight}
 	}
 
-	if _, err := toml.Decode(string(tomlData), &conf); err != nil {
-		log.Fatal("Error cannot parse config.toml")
+	// The site's theme and any themes it inherits from
+	var err error
+	themeDirs, err = themeChain(relPath+projectDir+string(filepath.Separator)+"theme", conf.Theme)
+	if err != nil {
+		log.Fatal(err)
 	}
+}
+
+func buildProject() error {
+	var err error
+
+	// Read config.toml
+	loadConfig()
 
 	// Wipe entire "compiled" directory
 	deleteDirectoryContents(relPath + projectDir + string(filepath.Separator) + "compiled")
@@ -593,11 +817,52 @@ func buildProject() error {
 	// Build Pages
 	processDir(relPath+projectDir+string(filepath.Separator)+"pages", relPath+projectDir+string(filepath.Separator)+"compiled", "page")
 
-	// Make Navigation
-	nav := makeNav()
+	// Build error pages
+	processDir(relPath+projectDir+string(filepath.Separator)+"errors", relPath+projectDir+string(filepath.Separator)+"compiled", "error")
+
+	// Make Navigation, one per menu and language. It's rendered for each page, to mark the current page
+	addMenuLinks()
+	navClasses = themeNavClasses()
+	loadNavTemplates()
+	navs := make(map[string][]*navigationNode)
+	for _, menu := range siteMenus() {
+		for _, lang := range siteLanguages() {
+			navs[navKey(menu, lang)] = navTree(menu, lang)
+		}
+	}
 
 	// Write pages, replacing navigation token
-	writePages(nav)
+	writePages(navs)
+
+	// Write redirects for page aliases
+	err = writeRedirects()
+	if err != nil {
+		log.Fatal("Error redirects could not be written")
+	}
+
+	// Write the stylesheet for highlighted code
+	err = writeHighlightCSS()
+	if err != nil {
+		log.Fatal("Error syntax highlighting stylesheet could not be written")
+	}
+
+	// Write the search index and client
+	err = writeSearch()
+	if err != nil {
+		log.Fatal("Error search index could not be written")
+	}
+
+	// Write the JSON export of the site's content, if enabled
+	err = writeAPI()
+	if err != nil {
+		log.Fatal("Error API export could not be written")
+	}
+
+	// Write robots.txt, if configured for this environment
+	err = writeRobots()
+	if err != nil {
+		log.Fatal("Error robots.txt could not be written")
+	}
 
 	// Write a sitemap.xml.gz
 	err = createSitemap()
@@ -610,36 +875,73 @@ func buildProject() error {
 func createSitemap() error {
 	// Create a sitemap
 	compiledFolder := relPath + projectDir + string(filepath.Separator) + "compiled"
-	var siteMapElements []*sitemap.Item
-
-	// Create our sitemap from our pages map
-	for i := range siteMap {
-		element := new(sitemap.Item)
-		filename := siteMap[i]
+	urlSet := sitemapURLSet{
+		Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9",
+	}
+	if len(conf.Languages) > 0 {
+		urlSet.Xhtml = "http://www.w3.org/1999/xhtml"
+	}
 
-		// HTTP or HTTPS?
-		prefix := "http://"
-		if conf.Https == "on" {
-			prefix = "https://"
+	// Create our sitemap from our pages slice
+	for i := range pages {
+		element := sitemapURL{
+			Loc:        siteURL(pages[i].Link),
+			LastMod:    time.Now().Format(time.RFC3339),
+			Changefreq: "weekly",
 		}
-		// Replace filepath separator
-		element.Loc = strings.Replace(prefix+filename, string(filepath.Separator), "/", -1)
-		element.LastMod = time.Now()
-		element.Changefreq = "weekly"
-		if pages[i].Path == compiledFolder+string(filepath.Separator)+"index.html" {
-			element.Priority = 0.8
+		if pages[i].Key == "index.md" {
+			// Homepage, in any language
+			element.Priority = "0.8"
 		} else {
-			element.Priority = 0.3
+			element.Priority = "0.3"
 		}
-		siteMapElements = append(siteMapElements, element)
+
+		// Alternate language versions of this page
+		for _, alt := range translations(pages[i]) {
+			element.Alternates = append(element.Alternates, sitemapAlternate{
+				Rel:      "alternate",
+				Hreflang: hreflang(alt.Language),
+				Href:     siteURL(alt.Link),
+			})
+			if alt.Language == defaultLanguage() {
+				element.Alternates = append(element.Alternates, sitemapAlternate{
+					Rel:      "alternate",
+					Hreflang: "x-default",
+					Href:     siteURL(alt.Link),
+				})
+			}
+		}
+		urlSet.URLs = append(urlSet.URLs, element)
 	}
 
 	// Write sitemap.xml.gz
-	err := sitemap.SiteMap(compiledFolder+string(filepath.Separator)+"sitemap.xml.gz", siteMapElements)
+	file, err := os.Create(compiledFolder + string(filepath.Separator) + "sitemap.xml.gz")
 	if err != nil {
 		return err
 	}
-	return nil
+	defer file.Close()
+
+	zip := gzip.NewWriter(file)
+	_, err = zip.Write([]byte(xml.Header))
+	if err != nil {
+		return err
+	}
+	err = xml.NewEncoder(zip).Encode(urlSet)
+	if err != nil {
+		return err
+	}
+	return zip.Close()
+}
+
+// Returns the absolute URL of a link on this site
+func siteURL(link string) string {
+	// HTTP or HTTPS?
+	prefix := "http://"
+	if conf.Https == "on" {
+		prefix = "https://"
+	}
+	// Replace filepath separator
+	return strings.Replace(prefix+conf.Domain+link, string(filepath.Separator), "/", -1)
 }
 
 // buildCmd represents the build command
@@ -651,13 +953,26 @@ var buildCmd = &cobra.Command{
     Once built the website is available in the 'compiled' directory.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		project = strings.Join(args, " ")
+		environmentRequired = cmd.Flags().Changed("env")
 		err := buildProject()
 		if err != nil {
 			log.Fatal("Error unable to build project")
 		}
+
+		// Optionally verify links in the compiled output
+		if checkLinksFlag {
+			if checkLinks(false) > 0 {
+				log.Fatal("Error broken links found")
+			}
+		}
 	},
 }
 
 func init() {
 	RootCmd.AddCommand(buildCmd)
+	buildCmd.Flags().BoolVarP(&checkLinksFlag, "check-links", "", false, "Check links in the compiled site once built")
+	buildCmd.Flags().BoolVarP(&buildDrafts, "drafts", "", false, "Include draft pages")
+	buildCmd.Flags().BoolVarP(&buildFuture, "future", "", false, "Include pages with a publish date in the future")
+	buildCmd.Flags().BoolVarP(&buildExpired, "expired", "", false, "Include pages which have expired")
+	buildCmd.Flags().StringVarP(&environment, "env", "", "production", "The environment to build for, layers config.<env>.toml over config.toml")
 }
@@ -0,0 +1,138 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+var (
+	environment         string
+	environmentRequired bool
+)
+
+// Reads a config file and decodes it over the package config
+func decodeConfig(filename string) {
+	tomlData, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Fatal("Error " + filepath.Base(filename) + " could not be read")
+	}
+
+	if _, err := toml.Decode(string(tomlData), &conf); err != nil {
+		log.Fatal("Error cannot parse " + filepath.Base(filename))
+	}
+}
+
+// Replaces ${ENV_VAR} references with the value of the environment variable. Unset variables
+// are an error when an environment was asked for
+func interpolateEnv(s string) string {
+	var envToken = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+	return envToken.ReplaceAllStringFunc(s, func(token string) string {
+		name := envToken.FindStringSubmatch(token)[1]
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			if environmentRequired {
+				log.Fatal("Error environment variable " + name + " is not set")
+			}
+			log.Println("Warning environment variable " + name + " is not set")
+		}
+		return value
+	})
+}
+
+// Substitutes environment variables in every string of a decoded config value, once all config
+// files are read so values are only substituted once and never parsed as TOML
+func interpolateConfig(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(interpolateEnv(v.String()))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			interpolateConfig(v.Field(i))
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			interpolateConfig(v.Index(i))
+		}
+	case reflect.Interface:
+		// Values held in interfaces, such as params, are copied to be changed
+		if v.IsNil() || !v.CanSet() {
+			return
+		}
+		value := reflect.New(v.Elem().Type()).Elem()
+		value.Set(v.Elem())
+		interpolateConfig(value)
+		v.Set(value)
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(v.MapIndex(key))
+			interpolateConfig(value)
+			v.SetMapIndex(key, value)
+		}
+	}
+}
+
+// Layers the config for the active environment, e.g config.staging.toml, over config.toml
+func loadEnvironmentConfig() {
+	if environment == "" {
+		environment = "production"
+	}
+
+	envConfig := relPath + projectDir + string(filepath.Separator) + "config." + environment + ".toml"
+	if !dirExist(envConfig) {
+		// Only an error if an environment was asked for
+		if environmentRequired {
+			log.Fatal("Error project has no config." + environment + ".toml")
+		}
+		return
+	}
+	decodeConfig(envConfig)
+}
+
+// Replaces the [[environment]] token with the active environment
+func processEnvironment(content string) string {
+	return strings.Replace(content, "[[environment]]", environment, -1)
+}
+
+// Writes robots.txt from the robots config value, pointing crawlers at the sitemap
+func writeRobots() error {
+	if conf.Robots == "" {
+		return nil
+	}
+
+	robots := strings.TrimSpace(conf.Robots) + "\n"
+	if !strings.Contains(strings.ToLower(robots), "sitemap:") {
+		robots += "\nSitemap: " + siteURL("/sitemap.xml.gz") + "\n"
+	}
+
+	return writeFile(relPath+projectDir+string(filepath.Separator)+"compiled"+string(filepath.Separator)+"robots.txt", robots)
+}
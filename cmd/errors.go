@@ -0,0 +1,78 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// Error pages are written to the filenames hosts look for. GitHub Pages and Netlify serve 404.html,
+// nginx is usually configured with 'error_page 500 502 503 504 /50x.html'
+var errorPageFiles = map[string][]string{
+	"404":         {"404.html"},
+	"500":         {"500.html", "50x.html"},
+	"maintenance": {"maintenance.html"},
+}
+
+// Error pages built from the 'errors' directory, these are kept out of navigation, sitemap and search
+var errorPages []pageContent
+
+func processErrorFile(page string, dest string) {
+	// Work out the language and which error page this is, from its path within 'compiled'
+	compiledPath := relPath + projectDir + string(filepath.Separator) + "compiled"
+	rel, err := filepath.Rel(compiledPath, dest)
+	if err != nil {
+		log.Fatal("Error error page could not be built")
+	}
+	lang, key := pageLanguage(rel)
+
+	files, ok := errorPageFiles[strings.TrimSuffix(key, ".md")]
+	if !ok {
+		log.Println("Warning unknown error page " + rel)
+		return
+	}
+
+	output, elements, ok := renderPage(page)
+	if !ok {
+		return
+	}
+
+	for _, file := range files {
+		writeEl := compiledPath + string(filepath.Separator) + languagePrefix(lang) + file
+		errorPages = append(errorPages, pageContent{
+			Source:      page,
+			Path:        writeEl,
+			Content:     output,
+			Language:    lang,
+			Key:         key,
+			Link:        "/" + strings.Replace(languagePrefix(lang), string(filepath.Separator), "/", -1) + file,
+			Title:       pageConf.Meta.Title,
+			Description: pageConf.Meta.Description,
+			Search:      "off",
+			Template:    pageConf.Design.Template,
+			Meta:        pageConf.Meta,
+			Navigation:  pageConf.Navigation,
+			Elements:    elements,
+		})
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains 'facil export', which writes the site's content as JSON for use outside
+// of its templates. 'facil build' writes the same to compiled/api when enabled in config.toml
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+type (
+	api struct {
+		Enabled string
+	}
+
+	// The export schema, documented in the README
+	exportSite struct {
+		Domain          string          `json:"domain"`
+		URL             string          `json:"url"`
+		DefaultLanguage string          `json:"defaultLanguage,omitempty"`
+		Languages       []string        `json:"languages,omitempty"`
+		Pages           []exportPage    `json:"pages"`
+		Partials        []exportPartial `json:"partials"`
+	}
+
+	exportPage struct {
+		Source     string           `json:"source"`
+		URL        string           `json:"url"`
+		Link       string           `json:"link"`
+		Language   string           `json:"lang,omitempty"`
+		Template   string           `json:"template"`
+		Meta       exportMeta       `json:"meta"`
+		Navigation exportNavigation `json:"navigation"`
+		Elements   []exportElement  `json:"elements"`
+	}
+
+	exportMeta struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		Keywords    string `json:"keywords"`
+		Author      string `json:"author"`
+	}
+
+	exportNavigation struct {
+		Text   string   `json:"text"`
+		Order  int      `json:"order"`
+		Menus  []string `json:"menus"`
+		Hidden bool     `json:"hidden"`
+	}
+
+	exportElement struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+		Raw  string `json:"raw"`
+		HTML string `json:"html"`
+	}
+
+	exportPartial struct {
+		Name     string          `json:"name"`
+		HTML     string          `json:"html"`
+		Elements []exportElement `json:"elements"`
+	}
+)
+
+var exportFormat, exportOutput string
+
+func exportElements(elements []pageElement) []exportElement {
+	exported := []exportElement{}
+	for _, el := range elements {
+		exported = append(exported, exportElement{
+			Name: el.Name,
+			Type: el.Type,
+			Raw:  strings.Trim(el.Content, "\n"),
+			HTML: el.HTML,
+		})
+	}
+	return exported
+}
+
+// Returns the site's content, from the pages and partials parsed by a build
+func siteExport() exportSite {
+	site := exportSite{
+		Domain:          conf.Domain,
+		URL:             siteURL("/"),
+		DefaultLanguage: defaultLanguage(),
+		Pages:           []exportPage{},
+		Partials:        []exportPartial{},
+	}
+	for _, lang := range conf.Languages {
+		site.Languages = append(site.Languages, lang.Code)
+	}
+
+	for _, page := range pages {
+		// Navigation text falls back to the title, as it does in the navigation itself
+		text := page.Navigation.Text
+		if text == "" {
+			text = page.Meta.Title
+		}
+		link := strings.Replace(page.Link, string(filepath.Separator), "/", -1)
+
+		site.Pages = append(site.Pages, exportPage{
+			Source:   filepath.ToSlash(languagePrefix(page.Language) + page.Key),
+			URL:      siteURL(page.Link),
+			Link:     link,
+			Language: page.Language,
+			Template: page.Template,
+			Meta: exportMeta{
+				Title:       processSiteParams(page.Meta.Title),
+				Description: processSiteParams(page.Meta.Description),
+				Keywords:    processSiteParams(page.Meta.Keywords),
+				Author:      processSiteParams(page.Meta.Author),
+			},
+			Navigation: exportNavigation{
+				Text:   processSiteParams(text),
+				Order:  navOrder(page.Navigation.Order, page.Source),
+				Menus:  pageMenus(page.Navigation),
+				Hidden: page.Navigation.Hidden,
+			},
+			Elements: exportElements(page.Elements),
+		})
+	}
+
+	// Pages are read in directory order, sort so exports are repeatable
+	sort.Slice(site.Pages, func(i, j int) bool {
+		return site.Pages[i].Source < site.Pages[j].Source
+	})
+
+	var names []string
+	for name := range partialsOutput {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		site.Partials = append(site.Partials, exportPartial{
+			Name:     name,
+			HTML:     processSiteParams(partialsOutput[name]),
+			Elements: exportElements(partialsElements[name]),
+		})
+	}
+	return site
+}
+
+// Writes the export to compiled/api, if enabled
+func writeAPI() error {
+	if !optionOn(conf.API.Enabled, false) {
+		return nil
+	}
+	apiPath := relPath + projectDir + string(filepath.Separator) + "compiled" + string(filepath.Separator) + "api"
+	err := os.MkdirAll(apiPath, 0755)
+	if err != nil {
+		return err
+	}
+	return writeJSON(apiPath+string(filepath.Separator)+"index.json", siteExport())
+}
+
+// Parses the site's pages and partials as a build does, without writing anything
+func exportProject() error {
+	if exportFormat != "json" {
+		log.Fatal("Error unsupported export format " + exportFormat + ", json is supported")
+	}
+
+	// Diagnostics are logged to stderr, so they never mix with an export written to stdout
+	loadConfig()
+	err := parseSite()
+	if err != nil {
+		log.Fatal("Error could not read the site's pages")
+	}
+
+	content, err := json.MarshalIndent(siteExport(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if exportOutput == "" {
+		fmt.Println(string(content))
+		return nil
+	}
+	return writeFile(exportOutput, string(content))
+}
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Exports the website's content",
+	Long: `Exports every page's meta values, navigation, template, URL and elements, both as written
+	and rendered, along with the website's partials. For use by apps and other headless clients.
+
+	Uses --format flag to choose the format, json is supported. Output is written to stdout, or the file given with --output.
+	Pages are included as they would be built, use --env to export for an environment.
+	`,
+	Run: func(cmd *cobra.Command, args []string) {
+		project = strings.Join(args, " ")
+		environmentRequired = cmd.Flags().Changed("env")
+		err := exportProject()
+		if err != nil {
+			log.Fatal("Error unable to export project")
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringVarP(&exportFormat, "format", "", "json", "The export format, json")
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "", "", "File to write the export to, stdout if omitted")
+	exportCmd.Flags().StringVarP(&environment, "env", "", "production", "The environment to export for, layers config.<env>.toml over config.toml")
+}
@@ -0,0 +1,200 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains syntax highlighting of fenced code blocks in html elements. Blocks are
+// highlighted with chroma at build time, using inline styles or classes with a generated stylesheet.
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+)
+
+type (
+	highlightOptions struct {
+		Enabled     string
+		Style       string
+		CSS         string
+		LineNumbers string `toml:"linenumbers"`
+	}
+
+	// Wraps a markdown renderer, highlighting fenced code blocks
+	highlightRenderer struct {
+		renderer markdownRenderer
+		options  highlightOptions
+	}
+)
+
+var (
+	fenceToken = regexp.MustCompile("^\\s{0,3}(`{3,}|~{3,})\\s*(.*)$")
+	// Placeholders are unique to each build, so they can't be written in markdown
+	highlightPlaceholder = "FACILHIGHLIGHT" + randomHex(8) + "N"
+	placeholderToken     = regexp.MustCompile(`(?:<p>)?` + highlightPlaceholder + `([0-9]+)(?:</p>)?`)
+)
+
+// Returns n random bytes as hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal("Error unable to generate a random token")
+	}
+	return hex.EncodeToString(b)
+}
+
+// Code blocks are swapped for placeholders before rendering, so neither renderer touches the highlighted html
+func (r highlightRenderer) Render(markdown []byte) []byte {
+	var output []string
+	var highlighted []string
+
+	lines := strings.Split(string(markdown), "\n")
+	for i := 0; i < len(lines); i++ {
+		match := fenceToken.FindStringSubmatch(lines[i])
+		if match == nil || strings.TrimSpace(match[2]) == "" {
+			output = append(output, lines[i])
+			continue
+		}
+
+		// Find the closing fence
+		end := -1
+		for j := i + 1; j < len(lines); j++ {
+			closing := strings.TrimSpace(lines[j])
+			if strings.HasPrefix(closing, match[1]) && strings.Trim(closing, match[1][:1]) == "" {
+				end = j
+				break
+			}
+		}
+		if end == -1 {
+			output = append(output, lines[i])
+			continue
+		}
+
+		code := strings.Join(lines[i+1:end], "\n") + "\n"
+		output = append(output, "", highlightPlaceholder+strconv.Itoa(len(highlighted)), "")
+		highlighted = append(highlighted, r.highlight(code, match[2]))
+		i = end
+	}
+
+	rendered := r.renderer.Render([]byte(strings.Join(output, "\n")))
+	return placeholderToken.ReplaceAllFunc(rendered, func(token []byte) []byte {
+		n, err := strconv.Atoi(string(placeholderToken.FindSubmatch(token)[1]))
+		if err != nil || n >= len(highlighted) {
+			return token
+		}
+		return []byte(highlighted[n])
+	})
+}
+
+// Parses highlighted lines, e.g. hl=1,3-5
+func highlightLines(value string) [][2]int {
+	var ranges [][2]int
+	for _, part := range strings.Split(value, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			continue
+		}
+		end := start
+		if len(bounds) == 2 {
+			if n, err := strconv.Atoi(strings.TrimSpace(bounds[1])); err == nil {
+				end = n
+			}
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges
+}
+
+// Highlights a code block. The info string is the language, optionally followed by hl=1,3-5 to
+// highlight lines and linenos=on/off to override the line numbers setting
+func (r highlightRenderer) highlight(code string, info string) string {
+	fields := strings.Fields(info)
+	lexer := lexers.Get(fields[0])
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	lexer = chroma.Coalesce(lexer)
+
+	lineNumbers := optionOn(r.options.LineNumbers, false)
+	var lines [][2]int
+	for _, field := range fields[1:] {
+		attr := strings.SplitN(field, "=", 2)
+		if len(attr) != 2 {
+			continue
+		}
+		switch attr[0] {
+		case "hl":
+			lines = highlightLines(attr[1])
+		case "linenos":
+			lineNumbers = optionOn(attr[1], lineNumbers)
+		}
+	}
+
+	formatter := chromahtml.New(
+		chromahtml.WithClasses(r.options.CSS == "classes"),
+		chromahtml.WithLineNumbers(lineNumbers),
+		chromahtml.HighlightLines(lines),
+		chromahtml.TabWidth(4),
+	)
+
+	iterator, err := lexer.Tokenise(nil, code)
+	if err != nil {
+		log.Fatal("Error code block could not be highlighted")
+	}
+	var output bytes.Buffer
+	err = formatter.Format(&output, styles.Get(r.options.Style), iterator)
+	if err != nil {
+		log.Fatal("Error code block could not be highlighted")
+	}
+	return output.String()
+}
+
+// Writes the stylesheet for highlighted code to compiled/css/syntax.css when classes are used
+func writeHighlightCSS() error {
+	if !optionOn(conf.Highlight.Enabled, false) || conf.Highlight.CSS != "classes" {
+		return nil
+	}
+
+	cssPath := relPath + projectDir + string(filepath.Separator) + "compiled" + string(filepath.Separator) + "css"
+	err := os.MkdirAll(cssPath, 0755)
+	if err != nil {
+		return err
+	}
+
+	var output bytes.Buffer
+	formatter := chromahtml.New(chromahtml.WithClasses(true))
+	err = formatter.WriteCSS(&output, styles.Get(conf.Highlight.Style))
+	if err != nil {
+		return err
+	}
+	return writeFile(cssPath+string(filepath.Separator)+"syntax.css", output.String())
+}
@@ -0,0 +1,268 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file converts HTML to markdown for importing content. Element blocks in page files are
+// delimited by ***, so the markdown written never contains an asterisk: lists use - bullets,
+// strong and emphasis use __ and _, and literal asterisks are written as &#42;
+
+package cmd
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	whitespace = regexp.MustCompile(`\s+`)
+	blankLines = regexp.MustCompile(`\n{3,}`)
+)
+
+// Converts HTML nodes to markdown
+func htmlToMarkdown(nodes []*html.Node) string {
+	md := blocksMarkdown(nodes)
+	return strings.TrimSpace(blankLines.ReplaceAllString(md, "\n\n"))
+}
+
+// Parses an HTML fragment as the content of a body
+func htmlFragment(s string) ([]*html.Node, error) {
+	return html.ParseFragment(strings.NewReader(s), &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body})
+}
+
+// Converts an HTML fragment to markdown
+func htmlStringToMarkdown(s string) (string, error) {
+	nodes, err := htmlFragment(s)
+	if err != nil {
+		return "", err
+	}
+	return htmlToMarkdown(nodes), nil
+}
+
+// Escapes text so it isn't read as markdown, or as the end of an element
+func escapeMarkdown(s string) string {
+	s = strings.Replace(s, "*", "&#42;", -1)
+	s = strings.Replace(s, "`", "\\`", -1)
+	s = strings.Replace(s, "[", "\\[", -1)
+	return strings.Replace(s, "]", "\\]", -1)
+}
+
+// Renders a node as HTML, for anything markdown has no syntax for
+func rawHTML(n *html.Node) string {
+	var buf bytes.Buffer
+	html.Render(&buf, n)
+	return strings.Replace(buf.String(), "*", "&#42;", -1)
+}
+
+func attr(n *html.Node, name string) string {
+	for _, a := range n.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// Returns the text within a node
+func nodeText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var text string
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		text += nodeText(c)
+	}
+	return text
+}
+
+func childrenMarkdown(n *html.Node, block bool) string {
+	var children []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		children = append(children, c)
+	}
+	if block {
+		return blocksMarkdown(children)
+	}
+
+	var md string
+	for _, c := range children {
+		md += inlineMarkdown(c)
+	}
+	return md
+}
+
+// Converts a run of block level nodes, inline content between blocks becomes a paragraph
+func blocksMarkdown(nodes []*html.Node) string {
+	var md, paragraph string
+	for _, n := range nodes {
+		if n.Type == html.TextNode || (n.Type == html.ElementNode && isInline(n.Data)) {
+			paragraph += inlineMarkdown(n)
+			continue
+		}
+		if strings.TrimSpace(paragraph) != "" {
+			md += "\n\n" + strings.TrimSpace(paragraph) + "\n\n"
+		}
+		paragraph = ""
+		md += blockMarkdown(n)
+	}
+	if strings.TrimSpace(paragraph) != "" {
+		md += "\n\n" + strings.TrimSpace(paragraph) + "\n\n"
+	}
+	return md
+}
+
+// Converts a block level node
+func blockMarkdown(n *html.Node) string {
+	if n.Type != html.ElementNode {
+		return childrenMarkdown(n, true)
+	}
+
+	switch n.Data {
+	case "script", "style", "noscript", "template", "head":
+		return ""
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level, _ := strconv.Atoi(n.Data[1:])
+		return "\n\n" + strings.Repeat("#", level) + " " + strings.TrimSpace(childrenMarkdown(n, false)) + "\n\n"
+	case "p":
+		return "\n\n" + strings.TrimSpace(childrenMarkdown(n, false)) + "\n\n"
+	case "hr":
+		return "\n\n---\n\n"
+	case "ul", "ol":
+		return "\n\n" + listMarkdown(n, 0) + "\n\n"
+	case "blockquote":
+		inner := strings.TrimSpace(blankLines.ReplaceAllString(childrenMarkdown(n, true), "\n\n"))
+		return "\n\n> " + strings.Replace(inner, "\n", "\n> ", -1) + "\n\n"
+	case "pre":
+		code := nodeText(n)
+		if strings.Contains(code, "*") || strings.Contains(code, "```") {
+			return "\n\n" + rawHTML(n) + "\n\n"
+		}
+		lang := ""
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && c.Data == "code" {
+				for _, class := range strings.Fields(attr(c, "class")) {
+					if strings.HasPrefix(class, "language-") {
+						lang = strings.TrimPrefix(class, "language-")
+					}
+				}
+			}
+		}
+		return "\n\n```" + lang + "\n" + strings.TrimRight(code, "\n") + "\n```\n\n"
+	case "table", "dl", "figure", "iframe", "video", "audio", "form":
+		return "\n\n" + rawHTML(n) + "\n\n"
+	}
+
+	// div, section, article and anything else, only their content is kept
+	return "\n\n" + childrenMarkdown(n, true) + "\n\n"
+}
+
+// Converts list items, nested lists are indented beneath their item
+func listMarkdown(n *html.Node, depth int) string {
+	var md string
+	indent := strings.Repeat("    ", depth)
+	i := 1
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.Data != "li" {
+			continue
+		}
+
+		marker := "- "
+		if n.Data == "ol" {
+			marker = strconv.Itoa(i) + ". "
+			i++
+		}
+
+		var text, nested string
+		for c := li.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && (c.Data == "ul" || c.Data == "ol") {
+				nested += "\n" + listMarkdown(c, depth+1)
+			} else if c.Type == html.ElementNode && c.Data == "p" {
+				text += " " + childrenMarkdown(c, false)
+			} else {
+				text += inlineMarkdown(c)
+			}
+		}
+		md += indent + marker + strings.TrimSpace(text) + nested + "\n"
+	}
+	return strings.TrimRight(md, "\n")
+}
+
+func isInline(tag string) bool {
+	switch tag {
+	case "a", "abbr", "b", "br", "cite", "code", "em", "i", "img", "kbd", "mark", "q", "s", "small", "span", "strike", "strong", "sub", "sup", "time", "u", "del", "ins":
+		return true
+	}
+	return false
+}
+
+// Converts an inline node
+func inlineMarkdown(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return escapeMarkdown(whitespace.ReplaceAllString(n.Data, " "))
+	}
+	if n.Type != html.ElementNode {
+		return ""
+	}
+
+	inner := childrenMarkdown(n, false)
+	switch n.Data {
+	case "br":
+		return "  \n"
+	case "strong", "b":
+		return wrapInline(inner, "__")
+	case "em", "i":
+		return wrapInline(inner, "_")
+	case "code":
+		code := nodeText(n)
+		if strings.Contains(code, "*") || strings.Contains(code, "`") {
+			return rawHTML(n)
+		}
+		return "`" + code + "`"
+	case "a":
+		href := attr(n, "href")
+		if href == "" {
+			return inner
+		}
+		return "[" + strings.TrimSpace(inner) + "](" + href + ")"
+	case "img":
+		return "![" + escapeMarkdown(attr(n, "alt")) + "](" + attr(n, "src") + ")"
+	case "script", "style":
+		return ""
+	case "s", "strike", "del", "sub", "sup", "mark", "u", "ins", "kbd", "abbr", "q", "small":
+		return rawHTML(n)
+	}
+
+	// Block elements found inline, inside a link for example, only their content is kept
+	return inner
+}
+
+// Wraps inline content in emphasis markers, keeping surrounding spaces outside them
+func wrapInline(s string, marker string) string {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return s
+	}
+	leading := s[:strings.Index(s, trimmed)]
+	trailing := s[len(leading)+len(trimmed):]
+	return leading + marker + trimmed + marker + trailing
+}
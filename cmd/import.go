@@ -0,0 +1,328 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains 'facil import', which creates pages from an existing static HTML website
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/spf13/cobra"
+)
+
+// Describes where content is found in the HTML files being imported, with CSS selectors
+type importMapping struct {
+	Template   string
+	Navigation string
+	Meta       map[string]string
+	Elements   map[string]string
+}
+
+var (
+	importMappingFile string
+	importForce       bool
+)
+
+func isHTMLFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".html" || ext == ".htm"
+}
+
+// Returns the text of a selection with whitespace collapsed, for meta tags their content
+func selectionText(s *goquery.Selection) string {
+	text := s.Text()
+	if goquery.NodeName(s) == "meta" {
+		text, _ = s.Attr("content")
+	}
+	return strings.TrimSpace(whitespace.ReplaceAllString(text, " "))
+}
+
+// Resolves a link in the source site to the path of the HTML file it points at, relative to the
+// site's root. Links to other sites, or to files which don't exist, return an empty string
+func resolveSourceLink(source string, from string, href string) string {
+	u, err := url.Parse(href)
+	if err != nil || u.Scheme != "" || u.Host != "" || (u.Path == "" && u.Fragment != "") {
+		return ""
+	}
+
+	// Relative links are resolved from the page they're on
+	p := u.Path
+	if !strings.HasPrefix(p, "/") {
+		isDir := p == "" || strings.HasSuffix(p, "/")
+		p = path.Join(path.Dir("/"+from), p)
+		if isDir {
+			p += "/"
+		}
+	}
+	p = strings.TrimLeft(p, "/")
+
+	candidates := []string{p}
+	if p == "" || strings.HasSuffix(p, "/") {
+		candidates = []string{p + "index.html", p + "index.htm"}
+	} else if !isHTMLFile(p) {
+		candidates = []string{p + ".html", p + "/index.html"}
+	}
+	for _, c := range candidates {
+		if dirExist(source + string(filepath.Separator) + filepath.FromSlash(c)) {
+			return c
+		}
+	}
+	return ""
+}
+
+// Reads the navigation of the source site, from its home page, returning each page's position and link text
+func sourceNavigation(source string, selector string) (map[string]int, map[string]string) {
+	orders := make(map[string]int)
+	texts := make(map[string]string)
+	if selector == "" {
+		return orders, texts
+	}
+
+	for _, home := range []string{"index.html", "index.htm"} {
+		f, err := os.Open(source + string(filepath.Separator) + home)
+		if err != nil {
+			continue
+		}
+		doc, err := goquery.NewDocumentFromReader(f)
+		f.Close()
+		if err != nil {
+			log.Fatal("Error reading " + home + " from the site being imported")
+		}
+
+		order := 1
+		doc.Find(selector).Each(func(i int, s *goquery.Selection) {
+			href, _ := s.Attr("href")
+			target := resolveSourceLink(source, home, href)
+			if target == "" {
+				return
+			}
+			if _, seen := orders[target]; !seen {
+				orders[target] = order
+				texts[target] = selectionText(s)
+				order++
+			}
+		})
+		break
+	}
+	return orders, texts
+}
+
+// Returns the page file for an HTML file, each part of its path is slugified
+func importPagePath(rel string) string {
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i := range parts {
+		if i == len(parts)-1 {
+			parts[i] = strings.TrimSuffix(parts[i], filepath.Ext(parts[i]))
+		}
+		parts[i] = slugify(parts[i])
+	}
+	return filepath.FromSlash(strings.Join(parts, "/")) + ".md"
+}
+
+// Returns the link the page imported from an HTML file is built at
+func importedLink(rel string) string {
+	lang, key := pageLanguage(importPagePath(rel))
+	_, link := pagePaths(languagePrefix(lang) + key)
+	return strings.Replace(link, string(filepath.Separator), "/", -1)
+}
+
+// Creates a page from an HTML file, using the mapping to find its meta values and elements
+func importHTMLFile(source string, rel string, template string, mapping importMapping, values pageScaffold) (pageScaffold, error) {
+	f, err := os.Open(source + string(filepath.Separator) + rel)
+	if err != nil {
+		return values, err
+	}
+	defer f.Close()
+
+	doc, err := goquery.NewDocumentFromReader(f)
+	if err != nil {
+		return values, err
+	}
+
+	// Links to other pages of the site point at where they're imported to
+	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		href, _ := s.Attr("href")
+		target := resolveSourceLink(source, filepath.ToSlash(rel), href)
+		if target == "" || !isHTMLFile(target) {
+			return
+		}
+		link := importedLink(target)
+		if u, err := url.Parse(href); err == nil && u.Fragment != "" {
+			link += "#" + u.Fragment
+		}
+		s.SetAttr("href", link)
+	})
+
+	for name, selector := range mapping.Meta {
+		values.Meta[name] = selectionText(doc.Find(selector).First())
+	}
+
+	for _, element := range templateElements(template) {
+		selector := mapping.Elements[element.Name]
+		if selector == "" {
+			continue
+		}
+		selection := doc.Find(selector)
+		if selection.Length() == 0 {
+			continue
+		}
+
+		if element.Type == "text" {
+			values.Elements[element.Name] = strings.Replace(selectionText(selection.First()), "*", "&#42;", -1)
+		} else {
+			values.Elements[element.Name] = htmlToMarkdown(selection.Nodes)
+		}
+	}
+	return values, nil
+}
+
+func importSite(source string) error {
+	loadConfig()
+
+	if !dirExist(source) {
+		log.Fatal("Error cannot find the site to import " + source)
+	}
+
+	// Read the mapping
+	tomlData, err := ioutil.ReadFile(importMappingFile)
+	if err != nil {
+		log.Fatal("Error " + importMappingFile + " could not be read")
+	}
+	var mapping importMapping
+	if _, err := toml.Decode(string(tomlData), &mapping); err != nil {
+		log.Fatal("Error cannot parse " + importMappingFile)
+	}
+	if mapping.Template == "" {
+		mapping.Template = "default"
+	}
+
+	templatePath, found := findThemeFile(themeDirs, mapping.Template+".html")
+	if !found {
+		log.Fatal("Error cannot find specified theme template")
+	}
+	temp, err := ioutil.ReadFile(templatePath)
+	if err != nil {
+		return err
+	}
+
+	orders, texts := sourceNavigation(source, mapping.Navigation)
+	pagesPath := relPath + projectDir + string(filepath.Separator) + "pages"
+
+	return filepath.Walk(source, func(filename string, f os.FileInfo, err error) error {
+		if err != nil || f.IsDir() || !isHTMLFile(filename) {
+			return err
+		}
+		rel, err := filepath.Rel(source, filename)
+		if err != nil {
+			return err
+		}
+
+		dest := pagesPath + string(filepath.Separator) + importPagePath(rel)
+		if dirExist(dest) && !importForce {
+			fmt.Println(rel + ": skipped, " + importPagePath(rel) + " already exists")
+			return nil
+		}
+
+		// Pages in the source site's navigation keep their place in it
+		values := pageScaffold{
+			Template: mapping.Template,
+			Meta:     make(map[string]string),
+			Elements: make(map[string]string),
+		}
+		if order, ok := orders[filepath.ToSlash(rel)]; ok {
+			values.Order = strconv.Itoa(order)
+			values.NavText = texts[filepath.ToSlash(rel)]
+		} else if mapping.Navigation != "" {
+			// Pages the old site didn't link from its menu stay out of navigation
+			values.Hidden = true
+		}
+
+		values, err = importHTMLFile(source, rel, string(temp), mapping, values)
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(filepath.Dir(dest), 0755)
+		if err != nil {
+			return err
+		}
+		err = writePageFile(dest, string(temp), values)
+		if err != nil {
+			return err
+		}
+		fmt.Println(rel + ": imported to pages" + string(filepath.Separator) + importPagePath(rel))
+		return nil
+	})
+}
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Imports an existing website into pages",
+	Long: `Creates pages from the HTML files of an existing static website, keeping its directory structure.
+
+	Uses --mapping flag to specify a TOML file of CSS selectors, which say where the meta values and
+	elements of the chosen template are found in each HTML file. Existing pages are skipped unless --force is used.
+
+	Uses --wordpress flag to import pages and posts from a WordPress export file instead, 'facil import --wordpress export.xml'.
+	Content goes into the element given by --element, uploads are copied from --uploads or downloaded into the theme.
+	`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if wordpressFile != "" {
+			project = strings.Join(args, " ")
+			err := importWordPress(wordpressFile)
+			if err != nil {
+				log.Fatal("Error unable to import WordPress export")
+			}
+			return
+		}
+
+		if len(args) < 1 {
+			log.Fatal("Error specify the directory of the site to import")
+		}
+		project = strings.Join(args[1:], " ")
+
+		err := importSite(args[0])
+		if err != nil {
+			log.Fatal("Error unable to import site")
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(importCmd)
+	importCmd.Flags().StringVarP(&importMappingFile, "mapping", "", "import.toml", "TOML file mapping CSS selectors to meta values and elements")
+	importCmd.Flags().BoolVarP(&importForce, "force", "", false, "Replace pages which already exist")
+	importCmd.Flags().StringVarP(&wordpressFile, "wordpress", "", "", "WordPress export (WXR) file to import pages and posts from")
+	importCmd.Flags().StringVarP(&template, "template", "", "default", "The template to use with pages imported from WordPress")
+	importCmd.Flags().StringVarP(&wordpressElement, "element", "", "", "The element WordPress content is imported into, the template's first html element if omitted")
+	importCmd.Flags().StringVarP(&wordpressUploads, "uploads", "", "", "Local copy of wp-content/uploads, otherwise uploads are downloaded")
+}
@@ -0,0 +1,191 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains the multilingual support used by 'facil build'. Languages are declared in
+// config.toml and pages are placed in a language directory (pages/es/about.md) or given a
+// language suffix (pages/about.es.md). Pages without either belong to the default language.
+
+package cmd
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Returns the default language code, the first declared language unless set in config.toml
+func defaultLanguage() string {
+	if conf.DefaultLanguage != "" {
+		return conf.DefaultLanguage
+	}
+	if len(conf.Languages) > 0 {
+		return conf.Languages[0].Code
+	}
+	return ""
+}
+
+// Returns all language codes in use, a site without languages has a single unnamed one
+func siteLanguages() []string {
+	if len(conf.Languages) == 0 {
+		return []string{""}
+	}
+	var codes []string
+	for i := range conf.Languages {
+		codes = append(codes, conf.Languages[i].Code)
+	}
+	return codes
+}
+
+func isLanguage(code string) bool {
+	for i := range conf.Languages {
+		if conf.Languages[i].Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// The hreflang attribute value for a language, defaults to the language code
+func hreflang(code string) string {
+	for i := range conf.Languages {
+		if conf.Languages[i].Code == code && conf.Languages[i].Hreflang != "" {
+			return conf.Languages[i].Hreflang
+		}
+	}
+	return code
+}
+
+func languageName(code string) string {
+	for i := range conf.Languages {
+		if conf.Languages[i].Code == code && conf.Languages[i].Name != "" {
+			return conf.Languages[i].Name
+		}
+	}
+	return code
+}
+
+// Works out the language of a page from its path relative to the pages directory. Also returns the
+// language neutral path, which is used to match translations of the same page
+func pageLanguage(rel string) (string, string) {
+	if len(conf.Languages) == 0 {
+		return "", rel
+	}
+
+	// Language directory
+	parts := strings.SplitN(rel, string(filepath.Separator), 2)
+	if len(parts) == 2 && isLanguage(parts[0]) {
+		return parts[0], parts[1]
+	}
+
+	// Language suffix
+	suffix := filepath.Ext(strings.TrimSuffix(rel, ".md"))
+	if suffix != "" && isLanguage(suffix[1:]) {
+		return suffix[1:], strings.TrimSuffix(rel, suffix+".md") + ".md"
+	}
+
+	return defaultLanguage(), rel
+}
+
+// Pages in the default language are written to the root of 'compiled', others to a language directory
+func languagePrefix(lang string) string {
+	if lang == "" || lang == defaultLanguage() {
+		return ""
+	}
+	return lang + string(filepath.Separator)
+}
+
+// Returns all versions of a page, including the page itself, ordered as the languages are declared
+func translations(page pageContent) []pageContent {
+	var found []pageContent
+	if page.Language == "" {
+		return found
+	}
+	for _, lang := range siteLanguages() {
+		for i := range pages {
+			if pages[i].Key == page.Key && pages[i].Language == lang {
+				found = append(found, pages[i])
+			}
+		}
+	}
+	return found
+}
+
+// Returns the home page for a language, used when a page has not been translated
+func languageHome(lang string) (pageContent, bool) {
+	for i := range pages {
+		if pages[i].Language == lang && pages[i].Key == "index.md" {
+			return pages[i], true
+		}
+	}
+	return pageContent{}, false
+}
+
+// Replaces [[language]], [[languages]] and [[alternates]] tokens in a page
+func processLanguages(page pageContent, content string) string {
+	if !strings.Contains(content, "[[language") && !strings.Contains(content, "[[alternates") {
+		return content
+	}
+
+	// [[language]] is the language code, for use in <html lang="">
+	content = strings.Replace(content, "[[language]]", hreflang(page.Language), -1)
+
+	alternates := translations(page)
+
+	// [[alternates]] are hreflang links for the page head
+	var links string
+	for _, alt := range alternates {
+		links += "<link rel=\"alternate\" hreflang=\"" + hreflang(alt.Language) + "\" href=\"" + siteURL(alt.Link) + "\">\n"
+		if alt.Language == defaultLanguage() {
+			links += "<link rel=\"alternate\" hreflang=\"x-default\" href=\"" + siteURL(alt.Link) + "\">\n"
+		}
+	}
+	content = strings.Replace(content, "[[alternates]]", strings.TrimRight(links, "\n"), -1)
+
+	// [[languages]] is a language switcher, links to the translated page or that language's home page
+	var switcher string
+	if len(conf.Languages) > 0 {
+		switcher = "<ul class=\"languages\">\n"
+		for _, lang := range siteLanguages() {
+			var target pageContent
+			var found bool
+			for _, alt := range alternates {
+				if alt.Language == lang {
+					target, found = alt, true
+				}
+			}
+			if !found {
+				target, found = languageHome(lang)
+			}
+			if !found {
+				continue
+			}
+
+			link := strings.Replace(target.Link, string(filepath.Separator), "/", -1)
+			class := ""
+			if lang == page.Language {
+				class = " class=\"current\""
+			}
+			switcher += "\t<li" + class + "><a href=\"" + link + "\" hreflang=\"" + hreflang(lang) + "\" lang=\"" + hreflang(lang) + "\">" + languageName(lang) + "</a></li>\n"
+		}
+		switcher += "</ul>"
+	}
+	content = strings.Replace(content, "[[languages]]", switcher, -1)
+
+	return content
+}
@@ -0,0 +1,414 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains the functionality behind 'facil links', which checks that links in the compiled
+// site resolve. Internal links are checked against 'compiled', external links optionally over HTTP.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/net/html"
+)
+
+type (
+	// A reference to a URL found in the compiled site
+	linkRef struct {
+		File string
+		Line int
+		URL  string
+	}
+
+	// Cached result of checking an external URL
+	linkStatus struct {
+		Status  int
+		Error   string
+		Checked time.Time
+	}
+)
+
+var (
+	checkLinksFlag   bool
+	checkExternal    bool
+	linkConcurrency  int
+	linkTimeout      int
+	linkCacheHours   int
+	cssURLToken      = regexp.MustCompile(`url\(\s*['"]?([^'")]+)['"]?\s*\)`)
+	linkAttributes   = map[string]bool{"href": true, "src": true, "poster": true, "data": true}
+	skippedLinkTypes = []string{"mailto:", "tel:", "javascript:", "data:", "#"}
+)
+
+// Finds all URL references in a html file, with the line each appears on
+func htmlLinks(file string) ([]linkRef, error) {
+	var refs []linkRef
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return refs, err
+	}
+
+	line := 1
+	inStyle := false
+	tokenizer := html.NewTokenizer(strings.NewReader(string(content)))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		raw := string(tokenizer.Raw())
+
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			inStyle = token.Data == "style"
+			for _, attr := range token.Attr {
+				switch {
+				case linkAttributes[attr.Key]:
+					refs = append(refs, linkRef{File: file, Line: line, URL: attr.Val})
+				case attr.Key == "srcset":
+					for _, candidate := range strings.Split(attr.Val, ",") {
+						fields := strings.Fields(candidate)
+						if len(fields) > 0 {
+							refs = append(refs, linkRef{File: file, Line: line, URL: fields[0]})
+						}
+					}
+				case attr.Key == "style":
+					refs = append(refs, cssLinks(file, line, attr.Val)...)
+				}
+			}
+		case html.EndTagToken:
+			inStyle = false
+		case html.TextToken:
+			if inStyle {
+				refs = append(refs, cssLinks(file, line, raw)...)
+			}
+		}
+		line += strings.Count(raw, "\n")
+	}
+	return refs, nil
+}
+
+// Finds url() references in CSS, starting at the given line
+func cssLinks(file string, line int, css string) []linkRef {
+	var refs []linkRef
+	for _, match := range cssURLToken.FindAllStringSubmatchIndex(css, -1) {
+		refs = append(refs, linkRef{
+			File: file,
+			Line: line + strings.Count(css[:match[0]], "\n"),
+			URL:  css[match[2]:match[3]],
+		})
+	}
+	return refs
+}
+
+// Finds all URL references in the compiled site's html and css files
+func collectLinks(compiledPath string) ([]linkRef, error) {
+	var refs []linkRef
+	err := filepath.Walk(compiledPath, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if f.IsDir() {
+			return nil
+		}
+
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".html", ".htm":
+			found, err := htmlLinks(path)
+			if err != nil {
+				return err
+			}
+			refs = append(refs, found...)
+		case ".css":
+			css, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			refs = append(refs, cssLinks(path, 1, string(css))...)
+		}
+		return nil
+	})
+	return refs, err
+}
+
+func isExternal(link string) bool {
+	lower := strings.ToLower(link)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "//")
+}
+
+// Checks an internal link resolves to a file in 'compiled'
+func internalLinkExists(compiledPath string, ref linkRef) bool {
+	link := ref.URL
+	if i := strings.IndexAny(link, "?#"); i != -1 {
+		link = link[:i]
+	}
+	if link == "" {
+		return true
+	}
+	if unescaped, err := url.PathUnescape(link); err == nil {
+		link = unescaped
+	}
+
+	// Root relative links resolve from 'compiled', others from the referring file
+	var target string
+	if strings.HasPrefix(link, "/") {
+		target = filepath.Join(compiledPath, filepath.FromSlash(link))
+	} else {
+		target = filepath.Join(filepath.Dir(ref.File), filepath.FromSlash(link))
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return false
+	}
+	if info.IsDir() {
+		return dirExist(filepath.Join(target, "index.html"))
+	}
+	return true
+}
+
+// Checks an external URL, trying GET where servers don't allow HEAD
+func fetchStatus(client *http.Client, link string) linkStatus {
+	if strings.HasPrefix(link, "//") {
+		link = "https:" + link
+	}
+
+	status := linkStatus{Checked: time.Now()}
+	resp, err := client.Head(link)
+	if err == nil && resp.StatusCode >= 400 {
+		resp.Body.Close()
+		resp, err = client.Get(link)
+	}
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		status.Error = err.Error()
+		return status
+	}
+	resp.Body.Close()
+	status.Status = resp.StatusCode
+	return status
+}
+
+// Checks external URLs concurrently, reusing recent results from the cache file in the site directory
+func checkExternalLinks(links []string) map[string]linkStatus {
+	cacheFile := relPath + projectDir + string(filepath.Separator) + ".linkcache.json"
+	cache := make(map[string]linkStatus)
+	if data, err := ioutil.ReadFile(cacheFile); err == nil {
+		json.Unmarshal(data, &cache)
+	}
+
+	var pending []string
+	for _, link := range links {
+		cached, found := cache[link]
+		if !found || time.Since(cached.Checked) > time.Duration(linkCacheHours)*time.Hour {
+			pending = append(pending, link)
+		}
+	}
+
+	results := make(map[string]linkStatus)
+	for _, link := range links {
+		if cached, found := cache[link]; found {
+			results[link] = cached
+		}
+	}
+
+	client := &http.Client{Timeout: time.Duration(linkTimeout) * time.Second}
+	jobs := make(chan string)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	workers := linkConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for link := range jobs {
+				status := fetchStatus(client, link)
+				mutex.Lock()
+				results[link] = status
+				mutex.Unlock()
+			}
+		}()
+	}
+	for _, link := range pending {
+		jobs <- link
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Only working links are cached, so failures are retried next time
+	for link, status := range results {
+		if status.Error == "" && status.Status < 400 {
+			cache[link] = status
+		}
+	}
+	err := writeJSON(cacheFile, cache)
+	if err != nil {
+		log.Println("Warning link cache could not be written")
+	}
+	return results
+}
+
+// Returns the source markdown file a compiled page was built from, if known
+func linkSource(file string) string {
+	for _, page := range append(pages, errorPages...) {
+		if filepath.Clean(page.Path) == filepath.Clean(file) {
+			return page.Source
+		}
+	}
+	return ""
+}
+
+// Checks all links in the compiled site, reporting broken ones. Returns the number found
+func checkLinks(external bool) int {
+	sitePath := relPath + projectDir + string(filepath.Separator)
+	compiledPath := sitePath + "compiled"
+
+	refs, err := collectLinks(compiledPath)
+	if err != nil {
+		log.Fatal("Error could not read compiled site")
+	}
+
+	var broken []string
+	var externalLinks []string
+	seen := make(map[string]bool)
+
+	for _, ref := range refs {
+		skip := strings.TrimSpace(ref.URL) == ""
+		for _, prefix := range skippedLinkTypes {
+			if strings.HasPrefix(strings.ToLower(ref.URL), prefix) {
+				skip = true
+			}
+		}
+		if skip {
+			continue
+		}
+
+		// Absolute links to this site are checked as internal links
+		for _, prefix := range []string{"http://", "https://", "//"} {
+			if strings.HasPrefix(ref.URL, prefix+conf.Domain+"/") {
+				ref.URL = strings.TrimPrefix(ref.URL, prefix+conf.Domain)
+			}
+		}
+
+		if isExternal(ref.URL) {
+			if !seen[ref.URL] {
+				seen[ref.URL] = true
+				externalLinks = append(externalLinks, ref.URL)
+			}
+			continue
+		}
+
+		if !internalLinkExists(compiledPath, ref) {
+			broken = append(broken, reportLink(sitePath, ref, "broken link"))
+		}
+	}
+
+	if external && len(externalLinks) > 0 {
+		statuses := checkExternalLinks(externalLinks)
+		for _, ref := range refs {
+			status, found := statuses[ref.URL]
+			if !found || !isExternal(ref.URL) {
+				continue
+			}
+			if status.Error != "" {
+				broken = append(broken, reportLink(sitePath, ref, "unreachable link ("+status.Error+")"))
+			} else if status.Status >= 400 {
+				broken = append(broken, reportLink(sitePath, ref, "broken link ("+strconv.Itoa(status.Status)+")"))
+			}
+		}
+	}
+
+	sort.Strings(broken)
+	for _, report := range broken {
+		fmt.Println(report)
+	}
+	fmt.Println(strconv.Itoa(len(broken)) + " broken links found")
+	return len(broken)
+}
+
+// Formats a broken link as file:line, with the page source where known
+func reportLink(sitePath string, ref linkRef, problem string) string {
+	// Paths are cleaned, walking the compiled site drops any leading ./
+	prefix := filepath.Clean(sitePath) + string(filepath.Separator)
+	file := strings.TrimPrefix(filepath.Clean(ref.File), prefix)
+	report := file + ":" + strconv.Itoa(ref.Line)
+	if source := linkSource(ref.File); source != "" {
+		report += " (" + strings.TrimPrefix(filepath.Clean(source), prefix) + ")"
+	}
+	return report + ": " + problem + " " + ref.URL
+}
+
+// linksCmd represents the links command
+var linksCmd = &cobra.Command{
+	Use:   "links",
+	Short: "Checks links in the compiled website",
+	Long: `Checks that links, images, scripts and CSS url() references in the compiled website resolve.
+
+    Uses --external flag to also check links to other websites. Results are cached for --cache hours.
+    `,
+	Run: func(cmd *cobra.Command, args []string) {
+		project = strings.Join(args, " ")
+		environmentRequired = cmd.Flags().Changed("env")
+		loadConfig()
+
+		if !dirExist(relPath + projectDir + string(filepath.Separator) + "compiled") {
+			log.Fatal("Error project has no compiled directory, build it first")
+		}
+
+		// Pages are parsed, not built, so broken links can be reported with their source
+		err := parseSite()
+		if err != nil {
+			log.Fatal("Error could not read the site's pages")
+		}
+
+		if checkLinks(checkExternal) > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(linksCmd)
+	linksCmd.Flags().BoolVarP(&checkExternal, "external", "", false, "Also check links to other websites")
+	linksCmd.Flags().IntVarP(&linkConcurrency, "concurrency", "", 8, "Number of external links to check at once")
+	linksCmd.Flags().IntVarP(&linkTimeout, "timeout", "", 10, "Seconds to wait for an external link")
+	linksCmd.Flags().IntVarP(&linkCacheHours, "cache", "", 24, "Hours to cache external link results for")
+	linksCmd.Flags().StringVarP(&environment, "env", "", "production", "The environment the site was built for")
+}
@@ -0,0 +1,163 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains the markdown renderers used for html elements. Blackfriday is the default,
+// goldmark can be chosen as a CommonMark compliant alternative. Extensions are toggled in config.toml.
+
+package cmd
+
+import (
+	"bytes"
+	"log"
+
+	"github.com/russross/blackfriday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+type (
+	// Renders the markdown of html elements
+	markdownRenderer interface {
+		Render(markdown []byte) []byte
+	}
+
+	markdownOptions struct {
+		Renderer        string
+		Footnotes       string
+		DefinitionLists string `toml:"definitionlists"`
+		HeadingIDs      string `toml:"headingids"`
+		HardWraps       string `toml:"hardwraps"`
+		Smartypants     string
+		Permalinks      string
+	}
+
+	blackfridayRenderer struct {
+		htmlFlags  int
+		extensions int
+	}
+
+	goldmarkRenderer struct {
+		markdown goldmark.Markdown
+	}
+)
+
+// The renderer in use, replaced when config.toml is read
+var mdRenderer markdownRenderer = newMarkdownRenderer(markdownOptions{})
+
+func (r blackfridayRenderer) Render(markdown []byte) []byte {
+	renderer := blackfriday.HtmlRenderer(r.htmlFlags, "", "")
+	return blackfriday.Markdown(markdown, renderer, r.extensions)
+}
+
+func (r goldmarkRenderer) Render(markdown []byte) []byte {
+	var output bytes.Buffer
+	if err := r.markdown.Convert(markdown, &output); err != nil {
+		log.Fatal("Error markdown could not be converted")
+	}
+	return output.Bytes()
+}
+
+// Reads an on/off option, empty options take the default
+func optionOn(option string, def bool) bool {
+	switch option {
+	case "on":
+		return true
+	case "off":
+		return false
+	}
+	return def
+}
+
+// Creates the renderer chosen in config.toml. Defaults match blackfriday.MarkdownCommon
+func newMarkdownRenderer(options markdownOptions) markdownRenderer {
+	footnotes := optionOn(options.Footnotes, false)
+	definitionLists := optionOn(options.DefinitionLists, true)
+	headingIDs := optionOn(options.HeadingIDs, false)
+	hardWraps := optionOn(options.HardWraps, false)
+	smartypants := optionOn(options.Smartypants, true)
+
+	switch options.Renderer {
+	case "", "blackfriday":
+		r := blackfridayRenderer{
+			htmlFlags: blackfriday.HTML_USE_XHTML,
+			extensions: blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
+				blackfriday.EXTENSION_TABLES |
+				blackfriday.EXTENSION_FENCED_CODE |
+				blackfriday.EXTENSION_AUTOLINK |
+				blackfriday.EXTENSION_STRIKETHROUGH |
+				blackfriday.EXTENSION_SPACE_HEADERS |
+				blackfriday.EXTENSION_HEADER_IDS |
+				blackfriday.EXTENSION_BACKSLASH_LINE_BREAK,
+		}
+		if smartypants {
+			r.htmlFlags |= blackfriday.HTML_USE_SMARTYPANTS |
+				blackfriday.HTML_SMARTYPANTS_FRACTIONS |
+				blackfriday.HTML_SMARTYPANTS_DASHES |
+				blackfriday.HTML_SMARTYPANTS_LATEX_DASHES
+		}
+		if footnotes {
+			r.extensions |= blackfriday.EXTENSION_FOOTNOTES
+		}
+		if definitionLists {
+			r.extensions |= blackfriday.EXTENSION_DEFINITION_LISTS
+		}
+		if headingIDs {
+			r.extensions |= blackfriday.EXTENSION_AUTO_HEADER_IDS
+		}
+		if hardWraps {
+			r.extensions |= blackfriday.EXTENSION_HARD_LINE_BREAK
+		}
+		return r
+
+	case "commonmark", "goldmark":
+		// Raw html is allowed, elements take markdown or html
+		extensions := []goldmark.Extender{extension.Table, extension.Strikethrough, extension.Linkify}
+		parserOptions := []parser.Option{parser.WithAttribute()}
+		htmlOptions := []renderer.Option{html.WithUnsafe(), html.WithXHTML()}
+
+		if smartypants {
+			extensions = append(extensions, extension.Typographer)
+		}
+		if footnotes {
+			extensions = append(extensions, extension.Footnote)
+		}
+		if definitionLists {
+			extensions = append(extensions, extension.DefinitionList)
+		}
+		if headingIDs {
+			parserOptions = append(parserOptions, parser.WithAutoHeadingID())
+		}
+		if hardWraps {
+			htmlOptions = append(htmlOptions, html.WithHardWraps())
+		}
+
+		return goldmarkRenderer{markdown: goldmark.New(
+			goldmark.WithExtensions(extensions...),
+			goldmark.WithParserOptions(parserOptions...),
+			goldmark.WithRendererOptions(htmlOptions...),
+		)}
+	}
+
+	log.Fatal("Error unknown markdown renderer " + options.Renderer)
+	return nil
+}
@@ -0,0 +1,367 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"log"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A link added to a menu from config.toml, for external links or pages built elsewhere
+type menuLink struct {
+	Menu     string
+	Text     string
+	Link     string
+	Order    string
+	Language string
+}
+
+// Pages appear in the 'main' menu unless they list the menus they belong to
+func pageMenus(nav navigation) []string {
+	if len(nav.Menus) == 0 {
+		return []string{"main"}
+	}
+	return nav.Menus
+}
+
+func inMenu(menus []string, menu string) bool {
+	for i := range menus {
+		if menus[i] == menu {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns every menu used by pages or config.toml, 'main' is always available
+func siteMenus() []string {
+	menus := []string{"main"}
+	for i := range navElements {
+		for _, menu := range navElements[i].Menus {
+			if !inMenu(menus, menu) {
+				menus = append(menus, menu)
+			}
+		}
+	}
+	return menus
+}
+
+// Adds the custom links from config.toml to the navigation. Links without a language
+// appear in the menu for every language
+func addMenuLinks() {
+	for _, m := range conf.Menus {
+		if m.Text == "" || m.Link == "" {
+			log.Println("Warning menu link needs text and a link")
+			continue
+		}
+
+		menu := m.Menu
+		if menu == "" {
+			menu = "main"
+		}
+
+		languages := []string{m.Language}
+		if m.Language == "" {
+			languages = siteLanguages()
+		}
+
+		// Custom links are always top level
+		for _, lang := range languages {
+			navElements = append(navElements, navigationContent{
+				Text:     m.Text,
+				Order:    navOrder(m.Order, "menu link "+m.Text),
+				Link:     m.Link,
+				Language: lang,
+				Menus:    []string{menu},
+			})
+		}
+	}
+}
+
+func navKey(menu string, lang string) string {
+	return menu + ":" + lang
+}
+
+// Replaces [[navigation]] and [[navigation menu="footer"]] tokens with the menu for the page's language,
+// marking the page's own link and the items above it
+func processNavigation(content string, page pageContent, navs map[string][]*navigationNode) string {
+	var navToken = regexp.MustCompile(`\[\[navigation(?:\smenu\=\"([a-zA-Z0-9_-]*)\")?]]`)
+	return navToken.ReplaceAllStringFunc(content, func(token string) string {
+		menu := navToken.FindStringSubmatch(token)[1]
+		if menu == "" {
+			menu = "main"
+		}
+		tree, ok := navs[navKey(menu, page.Language)]
+		if !ok {
+			log.Println("Warning unknown menu " + menu)
+			return ""
+		}
+		return navList(tree, 0, page.Link)
+	})
+}
+
+// Class names for the current page in navigation, set by the theme
+var navClasses themeNavigation
+
+// Markup for navigation lists and their items, from the theme's partials/nav-list.html and
+// partials/nav-item.html. Either left empty uses the built in markup
+var navListTemplate, navItemTemplate string
+
+// A menu item and the items nested beneath it
+type navigationNode struct {
+	Item     navigationContent
+	Children []*navigationNode
+}
+
+// Parses a navigation order, which must be a whole number. Items without one go last
+func navOrder(order string, source string) int {
+	order = strings.TrimSpace(order)
+	if order == "" {
+		return 99
+	}
+	n, err := strconv.Atoi(order)
+	if err != nil {
+		log.Fatal("Error navigation order \"" + order + "\" is not a number in " + source)
+	}
+	return n
+}
+
+// Returns the section a page represents, 'services/index.md' and 'services.md' are both 'services'
+func navSection(key string) string {
+	section := strings.TrimSuffix(filepath.ToSlash(key), ".md")
+	section = strings.TrimSuffix(section, "index")
+	return strings.TrimSuffix(section, "/")
+}
+
+// Builds the tree for a menu and language. A page's parent is the page for its section, where
+// a section has no page its pages move up to the nearest section which does
+func navTree(menu string, lang string) []*navigationNode {
+	// Only elements in this menu and language
+	var elements navigationItems
+	for i := range navElements {
+		if navElements[i].Language == lang && inMenu(navElements[i].Menus, menu) {
+			elements = append(elements, navElements[i])
+		}
+	}
+
+	// Sort elements, children are added in this order so each level is sorted too
+	sort.Sort(elements)
+
+	nodes := make([]*navigationNode, len(elements))
+	sections := make(map[string]*navigationNode)
+	for i := range elements {
+		nodes[i] = &navigationNode{Item: elements[i]}
+		if elements[i].Section != "" {
+			sections[elements[i].Section] = nodes[i]
+		}
+	}
+
+	var tree []*navigationNode
+	for _, node := range nodes {
+		var parent *navigationNode
+		section := node.Item.Section
+		for section != "" && parent == nil {
+			section = path.Dir(section)
+			if section == "." {
+				break
+			}
+			parent = sections[section]
+		}
+
+		if parent != nil {
+			parent.Children = append(parent.Children, node)
+		} else {
+			tree = append(tree, node)
+		}
+	}
+
+	navDuplicates(tree, menu, lang)
+	return tree
+}
+
+// Warns about items sharing an order within the same level, as their order then depends on their titles
+func navDuplicates(nodes []*navigationNode, menu string, lang string) {
+	where := menu + " menu"
+	if lang != "" {
+		where += " (" + lang + ")"
+	}
+
+	// Each level is sorted, so items sharing an order are next to each other
+	for i := 0; i < len(nodes); {
+		j := i + 1
+		texts := []string{nodes[i].Item.Text}
+		for j < len(nodes) && nodes[j].Item.Order == nodes[i].Item.Order {
+			texts = append(texts, nodes[j].Item.Text)
+			j++
+		}
+		if len(texts) > 1 {
+			log.Println("Warning duplicate navigation order " + strconv.Itoa(nodes[i].Item.Order) + " in " + where + ": " + strings.Join(texts, ", "))
+		}
+		i = j
+	}
+
+	for _, node := range nodes {
+		navDuplicates(node.Children, menu, lang)
+	}
+}
+
+// Reads the navigation class names from the theme, child themes override parent themes
+func themeNavClasses() themeNavigation {
+	classes := themeNavigation{}
+	for _, themePath := range themeDirs {
+		manifest, err := readThemeManifest(themePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if classes.Active == "" {
+			classes.Active = manifest.Navigation.Active
+		}
+		if classes.Trail == "" {
+			classes.Trail = manifest.Navigation.Trail
+		}
+	}
+
+	if classes.Active == "" {
+		classes.Active = "active"
+	}
+	if classes.Trail == "" {
+		classes.Trail = "active-trail"
+	}
+	return classes
+}
+
+// Checks whether an item is the current page or leads to it
+func navTrail(node *navigationNode, current string) bool {
+	if node.Item.Link == current {
+		return true
+	}
+	for _, child := range node.Children {
+		if navTrail(child, current) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks whether a theme partial is a navigation template rather than a partial for pages
+func isNavPartial(name string) bool {
+	name = strings.ToLower(filepath.ToSlash(name))
+	return name == "nav-item" || name == "nav-list" || name == "nav-item.html" || name == "nav-list.html"
+}
+
+// Reads the theme's navigation templates, child themes override parent themes
+func loadNavTemplates() {
+	navListTemplate, navItemTemplate = "", ""
+	if path, found := findThemeFile(themeDirs, "partials"+string(filepath.Separator)+"nav-list.html"); found {
+		tmp, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal("Error reading the navigation list template")
+		}
+		navListTemplate = string(tmp)
+	}
+	if path, found := findThemeFile(themeDirs, "partials"+string(filepath.Separator)+"nav-item.html"); found {
+		tmp, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal("Error reading the navigation item template")
+		}
+		navItemTemplate = string(tmp)
+	}
+}
+
+// Replaces [[item name=".."]] or [[list name=".."]] placeholders in a navigation template
+func navTemplate(template string, placeholder string, values map[string]string) string {
+	var token = regexp.MustCompile(`\[\[` + placeholder + `\sname\=\"([a-zA-Z0-9_-]*)\"]]`)
+	return token.ReplaceAllStringFunc(template, func(t string) string {
+		name := token.FindStringSubmatch(t)[1]
+		value, ok := values[name]
+		if !ok {
+			log.Println("Warning unknown navigation " + placeholder + " placeholder " + name)
+		}
+		return value
+	})
+}
+
+// Renders a level of the navigation tree as a nested list, for the page with the current link
+func navList(nodes []*navigationNode, depth int, current string) string {
+	var items string
+	for _, node := range nodes {
+		var children string
+		if len(node.Children) > 0 {
+			children = navList(node.Children, depth+1, current)
+		}
+		items += navItem(node, depth, current, children)
+	}
+
+	if navListTemplate != "" {
+		return navTemplate(navListTemplate, "list", map[string]string{
+			"items": items,
+			"level": strconv.Itoa(depth + 1),
+		})
+	}
+
+	indent := strings.Repeat("\t", depth*2)
+	return "<ul>\n" + items + indent + "</ul>"
+}
+
+// Renders a navigation item, with its already rendered children
+func navItem(node *navigationNode, depth int, current string, children string) string {
+	link := strings.Replace(node.Item.Link, string(filepath.Separator), "/", -1)
+
+	var class, aria string
+	if node.Item.Link == current {
+		class = navClasses.Active
+		aria = "aria-current=\"page\""
+	} else if navTrail(node, current) {
+		class = navClasses.Trail
+	}
+
+	if navItemTemplate != "" {
+		return navTemplate(navItemTemplate, "item", map[string]string{
+			"text":     node.Item.Text,
+			"link":     link,
+			"children": children,
+			"class":    class,
+			"current":  aria,
+			"level":    strconv.Itoa(depth + 1),
+		})
+	}
+
+	indent := strings.Repeat("\t", depth*2)
+	li := "<li>"
+	a := "<a href=\"" + link + "\">"
+	if aria != "" {
+		a = "<a href=\"" + link + "\" class=\"" + class + "\" " + aria + ">"
+	} else if class != "" {
+		li = "<li class=\"" + class + "\">"
+	}
+
+	html := indent + "\t" + li + a + node.Item.Text + "</a>"
+	if children != "" {
+		html += "\n" + indent + "\t\t" + children + "\n" + indent + "\t"
+	}
+	return html + "</li>\n"
+}
@@ -21,101 +21,187 @@
 package cmd
 
 import (
-	"fmt"
+	"bufio"
 	"io/ioutil"
 	"log"
+	"os"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/spf13/cobra"
 )
 
-var pageName, template string
+var (
+	pageName, template                string
+	pageTitle, pageNavText, pageOrder string
+	pageInteractive                   bool
+)
 
-func addPage() error {
-	// Need to understand our path
-	setRelPathProjDir()
+// Returns the name a page or section is compared by, names differing only in case, punctuation or
+// underscores and hyphens are taken to be the same
+func pageKey(name string) string {
+	return strings.Replace(slugify(name), "_", "-", -1)
+}
 
-	// Read config.toml
-	tomlData, err := ioutil.ReadFile(relPath + projectDir + string(filepath.Separator) + "config.toml")
+// Checks no other page or section in dir has a name which is the same as slug once slugified
+func pageCollision(dir string, slug string) {
+	objects, err := ioutil.ReadDir(dir)
 	if err != nil {
-		log.Fatal("Error config.toml could not be read")
+		return
 	}
+	for _, obj := range objects {
+		name := strings.TrimSuffix(obj.Name(), ".md")
+		if name != slug && pageKey(name) == pageKey(slug) {
+			log.Fatal("Error page name " + slug + " collides with " + obj.Name())
+		}
+	}
+}
 
-	if _, err := toml.Decode(string(tomlData), &conf); err != nil {
-		log.Fatal("Error cannot parse config.toml")
+// Reads the navigation order of a page, empty if it has none
+func pageNavOrder(path string) string {
+	markdown, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	start, end := frontMatterBounds(string(markdown))
+	if start == -1 {
+		return ""
+	}
+	var pc pageConfig
+	if _, err := toml.Decode(string(markdown[start:end]), &pc); err != nil {
+		return ""
 	}
+	return pc.Navigation.Order
+}
 
-	domain := conf.Domain
-	theme := conf.Theme
+// Returns the next free navigation order among the pages of a section. Pages at the
+// default order of 99 aren't counted
+func nextNavOrder(dir string) string {
+	var orders []string
+	objects, _ := ioutil.ReadDir(dir)
+	for _, obj := range objects {
+		if obj.IsDir() {
+			orders = append(orders, pageNavOrder(dir+string(filepath.Separator)+obj.Name()+string(filepath.Separator)+"index.md"))
+		} else if strings.HasSuffix(obj.Name(), ".md") && obj.Name() != "index.md" {
+			orders = append(orders, pageNavOrder(dir+string(filepath.Separator)+obj.Name()))
+		}
+	}
 
-	themePath := relPath + domain + string(filepath.Separator) + "theme" + string(filepath.Separator) + theme
-	sitePath := relPath + string(filepath.Separator) + domain + string(filepath.Separator) + "pages"
-	fmt.Println(themePath)
-	if !dirExist(themePath) {
-		log.Fatal("Error cannot find theme to create markdown templates")
+	next := 1
+	for _, order := range orders {
+		n, err := strconv.Atoi(strings.TrimSpace(order))
+		if err == nil && n != 99 && n >= next {
+			next = n + 1
+		}
 	}
-	if !dirExist(themePath + string(filepath.Separator) + template + ".html") {
+	return strconv.Itoa(next)
+}
+
+// Writes a page scaffolded from a template
+func writePageFile(filename string, temp string, values pageScaffold) error {
+	var fileOutput string
+	fileOutput += scaffoldFrontMatter(temp, values)
+	fileOutput += scaffoldElements(temp, values.Elements)
+	return writeFile(filename, fileOutput)
+}
+
+func addPage() error {
+	// Read config.toml, with any environment overrides
+	loadConfig()
+
+	sitePath := relPath + projectDir + string(filepath.Separator) + "pages"
+
+	// Template may be inherited from a parent theme
+	templatePath, found := findThemeFile(themeDirs, template+".html")
+	if !found {
 		log.Fatal("Error cannot find specified theme template")
 	}
 
-	// Only create the markdown file if it does not already exist
-	if !dirExist(sitePath + string(filepath.Separator) + pageName + ".md") {
-		var fileOutput string
+	// Open template file & get contents
+	temp, err := ioutil.ReadFile(templatePath)
+	if err != nil {
+		return err
+	}
 
-		// Open template file & get contents
-		temp, err := ioutil.ReadFile(themePath + string(filepath.Separator) + template + ".html")
-		if err != nil {
-			return err
+	if pageOrder != "" {
+		if _, err := strconv.Atoi(pageOrder); err != nil {
+			log.Fatal("Error --order must be a number")
 		}
+	}
 
-		// Parse meta with regex
-		var metaToken = regexp.MustCompile(`\[\[meta\sname\=\"([a-zA-Z0-9_-]*)\"]]`)
-		metaTokens := metaToken.FindAllStringSubmatch(string(temp), -1)
+	// Pages may be nested in sections, each part of the name is slugified
+	var slugs []string
+	for _, part := range strings.Split(filepath.ToSlash(strings.TrimSuffix(pageName, ".md")), "/") {
+		slug := slugify(part)
+		if slug == "" {
+			log.Fatal("Error invalid page name " + pageName)
+		}
+		slugs = append(slugs, slug)
+	}
 
-		// Compose meta output
+	// Create the directory for each section, with an index page if the section has no page
+	dir := sitePath
+	for i, slug := range slugs[:len(slugs)-1] {
+		pageCollision(dir, slug)
+		parent := dir
+		dir += string(filepath.Separator) + slug
 
-		fileOutput += "+++\n\n"
-		fileOutput += "[Meta]\n"
-		for i := range metaTokens {
-			fileOutput += metaTokens[i][1] + " = \"\"\n"
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
 		}
 
-		// Add navigation tokens
-		fileOutput += "\n[Navigation]\n"
-		fileOutput += "text = \"\"\n"
-		fileOutput += "order = \"99\"\n"
-
-		// Add design tokens
-		fileOutput += "\n[Design]\n"
-		fileOutput += "template = \"" + template + "\"\n"
-		fileOutput += "\n+++\n\n"
-
-		// Parse element with regex
-		var elementToken = regexp.MustCompile(`\[\[element\stype\=\"([a-zA-Z0-9]*)\"\sname\=\"([a-zA-Z0-9_-]*)\"\sdescription\=\"(.*)"]]`)
-		elementTokens := elementToken.FindAllStringSubmatch(string(temp), -1)
-
-		// Compose element output
-		for i := range elementTokens {
-			fileOutput += "***" + strings.ToUpper(elementTokens[i][1]) + "*** " + strings.Title(elementTokens[i][2]) + " (" + elementTokens[i][3] + ")\n\n"
-			if elementTokens[i][1] == "html" {
-				fileOutput += "# Your " + strings.Title(elementTokens[i][2]) + " markdown/html syntax here\n\n"
-			} else {
-				fileOutput += "Your " + strings.Title(elementTokens[i][2]) + " text syntax here\n\n"
+		// A language directory is not a section
+		if i == 0 && isLanguage(slug) {
+			continue
+		}
+		if !dirExist(dir+string(filepath.Separator)+"index.md") && !dirExist(parent+string(filepath.Separator)+slug+".md") {
+			text := strings.Title(strings.Replace(slug, "-", " ", -1))
+			err = writePageFile(dir+string(filepath.Separator)+"index.md", string(temp), pageScaffold{
+				Template: template,
+				Meta:     map[string]string{"title": text},
+				NavText:  text,
+				Order:    nextNavOrder(parent),
+			})
+			if err != nil {
+				return err
 			}
-
-			fileOutput += "***\n\n\n\n\n"
 		}
+	}
 
-		// Write to file
-		err = writeFile(sitePath+string(filepath.Separator)+pageName+".md", fileOutput)
-		if err != nil {
-			return err
-		}
+	name := slugs[len(slugs)-1]
+	pageCollision(dir, name)
+	filename := dir + string(filepath.Separator) + name + ".md"
+	if dirExist(filename) {
+		log.Fatal("Error page " + strings.Join(slugs, "/") + " already exists")
+	}
+
+	// A section's page is either its index.md or a page named after it, both are built to the same place
+	if name != "index" && dirExist(dir+string(filepath.Separator)+name+string(filepath.Separator)+"index.md") {
+		log.Fatal("Error page " + strings.Join(slugs, "/") + " collides with the section's " + name + "/index.md")
+	}
+	if name == "index" && len(slugs) > 1 && !(len(slugs) == 2 && isLanguage(slugs[0])) && dirExist(dir+".md") {
+		log.Fatal("Error page " + strings.Join(slugs, "/") + " collides with the section's page " + slugs[len(slugs)-2] + ".md")
 	}
-	return nil
+
+	// Values from flags, and prompts if interactive
+	values := pageScaffold{
+		Template: template,
+		Meta:     map[string]string{"title": pageTitle},
+		NavText:  pageNavText,
+		Order:    pageOrder,
+		Elements: make(map[string]string),
+	}
+	if values.Order == "" && !pageInteractive {
+		values.Order = nextNavOrder(dir)
+	}
+	if pageInteractive {
+		promptPage(bufio.NewReader(os.Stdin), string(temp), &values, nextNavOrder(dir))
+	}
+
+	return writePageFile(filename, string(temp), values)
 }
 
 // pageCmd represents the page command
@@ -125,6 +211,9 @@ var pageCmd = &cobra.Command{
 	Long: `Adds a new content page to the website based on the theme tempate specified.
 	
 	Uses --template flag to specify page template to build the markdown page from. Uses 'default' template if omitted.
+	Page names may be nested, 'facil page services/consulting' creates pages/services/consulting.md
+	and a services/index.md page for the section if it has none.
+	Uses --title, --nav-text and --order flags to fill in the page, or --interactive to be prompted for each meta value and element.
 	`,
 	Run: func(cmd *cobra.Command, args []string) {
 		pageName = strings.Join(args, " ")
@@ -139,4 +228,8 @@ var pageCmd = &cobra.Command{
 func init() {
 	RootCmd.AddCommand(pageCmd)
 	pageCmd.Flags().StringVarP(&template, "template", "", "default", "The template to use with new page")
+	pageCmd.Flags().StringVarP(&pageTitle, "title", "", "", "The page's meta title")
+	pageCmd.Flags().StringVarP(&pageNavText, "nav-text", "", "", "The page's navigation text")
+	pageCmd.Flags().StringVarP(&pageOrder, "order", "", "", "The page's navigation order")
+	pageCmd.Flags().BoolVarP(&pageInteractive, "interactive", "", false, "Prompt for the page's meta values and elements")
 }
@@ -0,0 +1,62 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Looks up a site parameter from the [Params] table, nested keys are separated by dots
+func siteParam(name string) (string, bool) {
+	var value interface{} = conf.Params
+	for _, key := range strings.Split(name, ".") {
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		value, ok = table[key]
+		if !ok {
+			return "", false
+		}
+	}
+
+	// Tables can't be output, only values
+	if _, ok := value.(map[string]interface{}); ok {
+		return "", false
+	}
+	return fmt.Sprint(value), true
+}
+
+// Replaces [[site name="phone"]] tokens with site parameter values
+func processSiteParams(content string) string {
+	var siteToken = regexp.MustCompile(`\[\[site\sname\=\"([a-zA-Z0-9_.-]*)\"]]`)
+	return siteToken.ReplaceAllStringFunc(content, func(token string) string {
+		name := siteToken.FindStringSubmatch(token)[1]
+		value, ok := siteParam(name)
+		if !ok {
+			log.Println("Warning unknown site parameter " + name)
+		}
+		return value
+	})
+}
@@ -0,0 +1,160 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains page alias handling for 'facil build'. Pages list their old URLs in an
+// 'aliases' front matter property, a redirect page is written at each, and optionally redirect
+// rules for Netlify, nginx and Apache are generated from the same data.
+
+package cmd
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type (
+	redirects struct {
+		Netlify string
+		Nginx   string
+		Apache  string
+	}
+
+	redirect struct {
+		From string
+		To   string
+	}
+)
+
+// Pattern matching a redirect's old path, directory style paths match with or without the trailing slash
+func (r redirect) pattern() string {
+	pattern := regexp.QuoteMeta(strings.TrimPrefix(r.From, "/"))
+	if strings.HasSuffix(r.From, "/") && r.From != "/" {
+		pattern += "?"
+	}
+	return pattern
+}
+
+const redirectHTML = `<!DOCTYPE html>
+<html>
+    <head>
+        <title>Redirecting</title>
+        <link rel="canonical" href="[[url]]">
+        <meta name="robots" content="noindex">
+        <meta http-equiv="refresh" content="0; url=[[link]]">
+    </head>
+    <body>
+        <p>This page has moved to <a href="[[link]]">[[link]]</a></p>
+    </body>
+</html>
+`
+
+// Returns the path to write an alias to, relative to 'compiled'. Aliases naming a file are used as
+// is, other aliases are treated as a page name and follow the pretty URL setting
+func aliasPath(alias string) string {
+	alias = strings.Trim(strings.Replace(alias, "/", string(filepath.Separator), -1), string(filepath.Separator))
+	ext := strings.ToLower(filepath.Ext(alias))
+	if ext == ".html" || ext == ".htm" {
+		return alias
+	}
+	writeRel, _ := pagePaths(alias + ".md")
+	return writeRel
+}
+
+// Writes a meta refresh page for every page alias, then any server redirect files configured
+func writeRedirects() error {
+	compiledPath := relPath + projectDir + string(filepath.Separator) + "compiled"
+	var rules []redirect
+
+	for i := range pages {
+		link := strings.Replace(pages[i].Link, string(filepath.Separator), "/", -1)
+
+		for _, alias := range pages[i].Aliases {
+			// Aliases may not reach outside compiled
+			writeRel := filepath.Clean(aliasPath(alias))
+			writeEl, err := archivePath(compiledPath, writeRel)
+			if err != nil {
+				log.Println("Warning alias " + alias + " is outside the compiled site, no redirect written")
+				continue
+			}
+
+			// Never overwrite a real page
+			if dirExist(writeEl) {
+				log.Println("Warning alias " + alias + " is an existing page, no redirect written")
+				continue
+			}
+
+			err = os.MkdirAll(filepath.Dir(writeEl), 0755)
+			if err != nil {
+				return err
+			}
+
+			content := strings.Replace(redirectHTML, "[[link]]", link, -1)
+			content = strings.Replace(content, "[[url]]", siteURL(pages[i].Link), -1)
+			err = writeFile(writeEl, content)
+			if err != nil {
+				return err
+			}
+
+			from := "/" + strings.TrimSuffix(strings.Replace(writeRel, string(filepath.Separator), "/", -1), "index.html")
+			rules = append(rules, redirect{From: from, To: link})
+		}
+	}
+
+	sitePath := relPath + projectDir + string(filepath.Separator)
+
+	// Netlify reads _redirects from the published directory
+	if conf.Redirects.Netlify == "on" {
+		var output string
+		for _, rule := range rules {
+			output += rule.From + " " + rule.To + " 301\n"
+		}
+		err := writeFile(compiledPath+string(filepath.Separator)+"_redirects", output)
+		if err != nil {
+			return err
+		}
+	}
+
+	// nginx and Apache snippets are written to the site directory, for inclusion in server config
+	if conf.Redirects.Nginx == "on" {
+		var output string
+		for _, rule := range rules {
+			output += "rewrite ^/" + rule.pattern() + "$ " + rule.To + " permanent;\n"
+		}
+		err := writeFile(sitePath+"redirects.nginx.conf", output)
+		if err != nil {
+			return err
+		}
+	}
+
+	if conf.Redirects.Apache == "on" {
+		output := "RewriteEngine On\n"
+		for _, rule := range rules {
+			output += "RewriteRule ^" + rule.pattern() + "$ " + rule.To + " [R=301,L]\n"
+		}
+		err := writeFile(sitePath+"redirects.apache.conf", output)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -10,6 +10,9 @@ import (
 
 var cfgFile string
 
+// Version of Facil, themes can declare the minimum version they require
+const Version = "0.1.0"
+
 // This represents the base command when called without any subcommands
 var RootCmd = &cobra.Command{
 	Use:   "facil",
@@ -55,6 +58,6 @@ func initConfig() {
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 }
@@ -0,0 +1,247 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains the scaffolding of markdown files from theme templates, shared by 'facil start'
+// and 'facil page'
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type (
+	// An element token read from a theme template
+	templateElement struct {
+		Type        string
+		Name        string
+		Description string
+	}
+
+	// Values to scaffold a page with, anything left empty is scaffolded blank or with placeholder text
+	pageScaffold struct {
+		Template string
+		Meta     map[string]string
+		NavText  string
+		Order    string
+		Hidden   bool
+		Draft    bool
+		Publish  string
+		Elements map[string]string
+	}
+)
+
+// Returns the names of the meta tokens in a template, in the order they first appear
+func templateMeta(template string) []string {
+	var metaToken = regexp.MustCompile(`\[\[meta\sname\=\"([a-zA-Z0-9_-]*)\"]]`)
+	metaTokens := metaToken.FindAllStringSubmatch(template, -1)
+
+	var names []string
+	seen := make(map[string]bool)
+	for i := range metaTokens {
+		if !seen[metaTokens[i][1]] {
+			seen[metaTokens[i][1]] = true
+			names = append(names, metaTokens[i][1])
+		}
+	}
+	return names
+}
+
+// Returns the element tokens in a template
+func templateElements(template string) []templateElement {
+	var elementToken = regexp.MustCompile(`\[\[element\stype\=\"([a-zA-Z0-9]*)\"\sname\=\"([a-zA-Z0-9_-]*)\"\sdescription\=\"([^"]*)"]]`)
+	elementTokens := elementToken.FindAllStringSubmatch(template, -1)
+
+	var elements []templateElement
+	for i := range elementTokens {
+		elements = append(elements, templateElement{
+			Type:        elementTokens[i][1],
+			Name:        elementTokens[i][2],
+			Description: elementTokens[i][3],
+		})
+	}
+	return elements
+}
+
+// Quotes a value as a TOML basic string, escaping control characters which it can't contain
+func tomlString(s string) string {
+	var quoted bytes.Buffer
+	quoted.WriteString("\"")
+	for _, r := range s {
+		switch r {
+		case '\\':
+			quoted.WriteString("\\\\")
+		case '"':
+			quoted.WriteString("\\\"")
+		case '\n':
+			quoted.WriteString("\\n")
+		case '\r':
+			quoted.WriteString("\\r")
+		case '\t':
+			quoted.WriteString("\\t")
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&quoted, "\\u%04X", r)
+			} else {
+				quoted.WriteRune(r)
+			}
+		}
+	}
+	quoted.WriteString("\"")
+	return quoted.String()
+}
+
+// Composes the TOML front matter for a page built on a template
+func scaffoldFrontMatter(template string, values pageScaffold) string {
+	var fileOutput string
+
+	// Publishing, only written when set
+	fileOutput += "+++\n"
+	if values.Draft {
+		fileOutput += "draft = true\n"
+	}
+	if values.Publish != "" {
+		fileOutput += "publish = " + values.Publish + "\n"
+	}
+
+	// Compose meta output
+	fileOutput += "\n[Meta]\n"
+	for _, name := range templateMeta(template) {
+		fileOutput += name + " = " + tomlString(values.Meta[name]) + "\n"
+	}
+
+	// Add navigation tokens
+	order := values.Order
+	if order == "" {
+		order = "99"
+	}
+	fileOutput += "\n[Navigation]\n"
+	fileOutput += "text = " + tomlString(values.NavText) + "\n"
+	fileOutput += "order = " + tomlString(order) + "\n"
+	if values.Hidden {
+		fileOutput += "hidden = true\n"
+	}
+
+	// Add design tokens
+	fileOutput += "\n[Design]\n"
+	fileOutput += "template = " + tomlString(values.Template) + "\n"
+	fileOutput += "\n+++\n\n"
+
+	return fileOutput
+}
+
+// Composes an element block, with placeholder text if there is no content
+func scaffoldElement(element templateElement, content string) string {
+	var fileOutput string
+	fileOutput += "***" + strings.ToUpper(element.Type) + "*** " + strings.Title(element.Name) + " (" + element.Description + ")\n\n"
+	if content != "" {
+		fileOutput += strings.TrimSpace(content) + "\n\n"
+	} else if element.Type == "html" {
+		fileOutput += "# Your " + strings.Title(element.Name) + " markdown/html syntax here\n\n"
+	} else {
+		fileOutput += "Your " + strings.Title(element.Name) + " text syntax here\n\n"
+	}
+	fileOutput += "***\n\n\n\n\n"
+	return fileOutput
+}
+
+// Composes the element blocks for each element in a template
+func scaffoldElements(template string, values map[string]string) string {
+	var fileOutput string
+	for _, element := range templateElements(template) {
+		fileOutput += scaffoldElement(element, values[element.Name])
+	}
+	return fileOutput
+}
+
+// Prompts for a single line of input, returning def if nothing is entered
+func promptLine(in *bufio.Reader, prompt string, def string) string {
+	if def != "" {
+		fmt.Print(prompt + " [" + def + "]: ")
+	} else {
+		fmt.Print(prompt + ": ")
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return def
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return def
+	}
+	return line
+}
+
+// Prompts for several lines of input, which end at a blank line
+func promptLines(in *bufio.Reader, prompt string) string {
+	fmt.Println(prompt + " (finish with a blank line, or leave blank to skip):")
+	var lines []string
+	for {
+		line, err := in.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		lines = append(lines, line)
+		if err != nil {
+			break
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Prompts for each meta value and element of a template, values already set are not asked for
+func promptPage(in *bufio.Reader, template string, values *pageScaffold, defaultOrder string) {
+	for _, name := range templateMeta(template) {
+		if values.Meta[name] == "" {
+			values.Meta[name] = promptLine(in, "Meta "+name, "")
+		}
+	}
+
+	if values.NavText == "" {
+		values.NavText = promptLine(in, "Navigation text", values.Meta["title"])
+	}
+	for values.Order == "" {
+		order := promptLine(in, "Navigation order", defaultOrder)
+		if _, err := strconv.Atoi(order); err != nil {
+			fmt.Println("Navigation order must be a number")
+			continue
+		}
+		values.Order = order
+	}
+
+	for _, element := range templateElements(template) {
+		if values.Elements[element.Name] != "" {
+			continue
+		}
+		content := promptLines(in, strings.Title(element.Name)+" ("+element.Description+")")
+		if strings.Contains(content, "*") {
+			fmt.Println("Element content can't contain *, use _ for emphasis. Placeholder text used instead")
+			content = ""
+		}
+		values.Elements[element.Name] = content
+	}
+}
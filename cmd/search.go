@@ -0,0 +1,230 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains client side search support for 'facil build'. A JSON index of every page is
+// written to compiled/search, along with a small JavaScript client used by the [[search]] token.
+
+package cmd
+
+import (
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type (
+	search struct {
+		Index string
+		Shard int
+	}
+
+	searchEntry struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		URL         string `json:"url"`
+		Language    string `json:"lang,omitempty"`
+		Text        string `json:"text"`
+	}
+
+	searchShards struct {
+		Shards []string `json:"shards"`
+	}
+)
+
+const searchForm = `<div class="facil-search">
+    <input type="search" class="facil-search-input" placeholder="Search" aria-label="Search">
+    <ul class="facil-search-results"></ul>
+</div>
+<script src="/search/search.js" data-index="/search/index.json"></script>`
+
+const searchJS = `(function () {
+    var script = document.currentScript;
+    var indexURL = script.getAttribute('data-index');
+    var container = script.previousElementSibling;
+    var input = container.querySelector('.facil-search-input');
+    var results = container.querySelector('.facil-search-results');
+    var lang = document.documentElement.getAttribute('lang');
+    var entries = null;
+
+    function get(url, callback) {
+        var xhr = new XMLHttpRequest();
+        xhr.onload = function () {
+            if (xhr.status === 200) {
+                callback(JSON.parse(xhr.responseText));
+            }
+        };
+        xhr.open('GET', url);
+        xhr.send();
+    }
+
+    // The index is a list of entries, or a list of shards holding the entries
+    function load(callback) {
+        if (entries !== null) {
+            return callback();
+        }
+        get(indexURL, function (index) {
+            if (index instanceof Array) {
+                entries = index;
+                return callback();
+            }
+            var loaded = [], pending = index.shards.length;
+            index.shards.forEach(function (shard) {
+                get(shard, function (part) {
+                    loaded = loaded.concat(part);
+                    if (--pending === 0) {
+                        entries = loaded;
+                        callback();
+                    }
+                });
+            });
+        });
+    }
+
+    function score(entry, terms) {
+        var total = 0;
+        var title = entry.title.toLowerCase();
+        var description = entry.description.toLowerCase();
+        var text = entry.text.toLowerCase();
+        for (var i = 0; i < terms.length; i++) {
+            var found = 0;
+            if (title.indexOf(terms[i]) !== -1) { found += 10; }
+            if (description.indexOf(terms[i]) !== -1) { found += 5; }
+            if (text.indexOf(terms[i]) !== -1) { found += 1; }
+            if (found === 0) {
+                return 0;
+            }
+            total += found;
+        }
+        return total;
+    }
+
+    function render(query) {
+        var terms = query.toLowerCase().split(/\s+/).filter(function (term) { return term !== ''; });
+        results.innerHTML = '';
+        if (terms.length === 0) {
+            return;
+        }
+        entries.map(function (entry) {
+            return { entry: entry, score: (lang && entry.lang && entry.lang !== lang) ? 0 : score(entry, terms) };
+        }).filter(function (match) {
+            return match.score > 0;
+        }).sort(function (a, b) {
+            return b.score - a.score;
+        }).forEach(function (match) {
+            var item = document.createElement('li');
+            var link = document.createElement('a');
+            link.href = match.entry.url;
+            link.textContent = match.entry.title || match.entry.url;
+            item.appendChild(link);
+            if (match.entry.description) {
+                var description = document.createElement('p');
+                description.textContent = match.entry.description;
+                item.appendChild(description);
+            }
+            results.appendChild(item);
+        });
+    }
+
+    input.addEventListener('input', function () {
+        load(function () {
+            render(input.value);
+        });
+    });
+})();
+`
+
+// Replaces the [[search]] token, which renders nothing unless the search index is enabled
+func processSearch(content string) string {
+	form := ""
+	if conf.Search.Index == "on" {
+		form = searchForm
+	}
+	return strings.Replace(content, "[[search]]", form, -1)
+}
+
+// Strips html tags from element output, leaving plain text for the index
+func stripHTML(content string) string {
+	var tags = regexp.MustCompile(`<[^>]*>`)
+	content = html.UnescapeString(tags.ReplaceAllString(content, " "))
+	return strings.Join(strings.Fields(content), " ")
+}
+
+// Writes the search index, sharded if configured, and the search client
+func writeSearch() error {
+	if conf.Search.Index != "on" {
+		return nil
+	}
+
+	searchPath := relPath + projectDir + string(filepath.Separator) + "compiled" + string(filepath.Separator) + "search"
+	err := os.MkdirAll(searchPath, 0755)
+	if err != nil {
+		return err
+	}
+
+	entries := []searchEntry{}
+	for i := range pages {
+		// Pages can opt out of the index
+		if pages[i].Search == "off" {
+			continue
+		}
+
+		var text []string
+		for _, el := range pages[i].Elements {
+			text = append(text, stripHTML(el.HTML))
+		}
+
+		entries = append(entries, searchEntry{
+			Title:       pages[i].Title,
+			Description: pages[i].Description,
+			URL:         strings.Replace(pages[i].Link, string(filepath.Separator), "/", -1),
+			Language:    hreflang(pages[i].Language),
+			Text:        strings.Join(text, " "),
+		})
+	}
+
+	var index interface{} = entries
+	if conf.Search.Shard > 0 {
+		// An empty list rather than null, so the client can always read the shards
+		shards := searchShards{Shards: make([]string, 0)}
+		for i := 0; i < len(entries); i += conf.Search.Shard {
+			end := i + conf.Search.Shard
+			if end > len(entries) {
+				end = len(entries)
+			}
+
+			name := "index-" + strconv.Itoa(len(shards.Shards)+1) + ".json"
+			err = writeJSON(searchPath+string(filepath.Separator)+name, entries[i:end])
+			if err != nil {
+				return err
+			}
+			shards.Shards = append(shards.Shards, "/search/"+name)
+		}
+		index = shards
+	}
+
+	err = writeJSON(searchPath+string(filepath.Separator)+"index.json", index)
+	if err != nil {
+		return err
+	}
+	return writeFile(searchPath+string(filepath.Separator)+"search.js", searchJS)
+}
@@ -49,6 +49,15 @@ func addDefaultTheme() error {
 		"themes" + string(filepath.Separator) + "default" + string(filepath.Separator) + "images",
 	}
 
+	// Theme manifest
+	const defaultManifest = `name = "default"
+version = "0.1.0"
+author = "Facil"
+description = "A (very) basic theme to get started with"
+templates = ["default"]
+facil = "0.1.0"
+`
+
 	// Templates
 	const defaultHTML = `
 <html>
@@ -99,7 +108,8 @@ some css
 
 	// Write 'default' theme files
 	defaultThemePath := basePath + "themes" + string(filepath.Separator) + "default" + string(filepath.Separator)
-	err := writeFile(defaultThemePath+"default.html", defaultHTML)
+	err := writeFile(defaultThemePath+"theme.toml", defaultManifest)
+	err = writeFile(defaultThemePath+"default.html", defaultHTML)
 	err = writeFile(defaultThemePath+"js"+string(filepath.Separator)+"facil.js", defaultJS)
 	err = writeFile(defaultThemePath+"css"+string(filepath.Separator)+"facil.css", defaultCSS)
 	err = writeFile(defaultThemePath+"partials"+string(filepath.Separator)+"footer.html", partialFooter)
@@ -0,0 +1,156 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains shortcode support for 'facil build'. Shortcodes are written in html element
+// markdown, e.g. {{< video src="https://youtu.be/abc" >}}, and are expanded before markdown conversion.
+// Themes can add their own as html snippets in a 'shortcodes' directory.
+
+package cmd
+
+import (
+	"html"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	shortcodeToken    = regexp.MustCompile(`\{\{<\s*([a-zA-Z0-9_-]+)((?:\s+[a-zA-Z0-9_-]+="[^"]*")*)\s*>}}`)
+	attributeToken    = regexp.MustCompile(`([a-zA-Z0-9_-]+)="([^"]*)"`)
+	builtinShortcodes = map[string]func(map[string]string) string{
+		"video":  videoShortcode,
+		"figure": figureShortcode,
+		"button": buttonShortcode,
+		"code":   codeShortcode,
+	}
+)
+
+// Parses name="value" attributes from a token
+func tokenAttributes(token string) map[string]string {
+	attrs := make(map[string]string)
+	for _, match := range attributeToken.FindAllStringSubmatch(token, -1) {
+		attrs[strings.ToLower(match[1])] = match[2]
+	}
+	return attrs
+}
+
+// Expands all shortcodes in element markdown. Theme shortcodes take precedence over built in ones
+func expandShortcodes(markdown string) string {
+	return shortcodeToken.ReplaceAllStringFunc(markdown, func(token string) string {
+		match := shortcodeToken.FindStringSubmatch(token)
+		name := strings.ToLower(match[1])
+		attrs := tokenAttributes(match[2])
+
+		if snippet, found := findThemeFile(themeDirs, "shortcodes"+string(filepath.Separator)+name+".html"); found {
+			return themeShortcode(snippet, attrs)
+		}
+		if shortcode, found := builtinShortcodes[name]; found {
+			return shortcode(attrs)
+		}
+
+		log.Println("Warning unknown shortcode " + name)
+		return token
+	})
+}
+
+// Theme shortcodes are html snippets with [[param name="x"]] placeholders for attributes
+func themeShortcode(snippet string, attrs map[string]string) string {
+	content, err := ioutil.ReadFile(snippet)
+	if err != nil {
+		log.Fatal("Error reading a shortcode template file")
+	}
+
+	var paramToken = regexp.MustCompile(`\[\[param\sname\=\"([a-zA-Z0-9_-]*)\"\s*]]`)
+	return paramToken.ReplaceAllStringFunc(strings.Trim(string(content), "\t\n "), func(token string) string {
+		name := strings.ToLower(paramToken.FindStringSubmatch(token)[1])
+		return html.EscapeString(attrs[name])
+	})
+}
+
+// Embeds a YouTube or Vimeo video, from its URL or an id and provider
+func videoShortcode(attrs map[string]string) string {
+	id, provider := attrs["id"], strings.ToLower(attrs["provider"])
+
+	if src, err := url.Parse(attrs["src"]); err == nil && attrs["src"] != "" {
+		host := strings.TrimPrefix(src.Host, "www.")
+		switch {
+		case host == "youtu.be":
+			id, provider = strings.Trim(src.Path, "/"), "youtube"
+		case strings.HasSuffix(host, "youtube.com"):
+			id, provider = src.Query().Get("v"), "youtube"
+			if id == "" {
+				id = filepath.Base(src.Path)
+			}
+		case strings.HasSuffix(host, "vimeo.com"):
+			id, provider = filepath.Base(src.Path), "vimeo"
+		}
+	}
+
+	var embed string
+	switch provider {
+	case "vimeo":
+		embed = "https://player.vimeo.com/video/" + url.PathEscape(id)
+	default:
+		embed = "https://www.youtube-nocookie.com/embed/" + url.PathEscape(id)
+	}
+
+	return "<div class=\"video\">\n<iframe src=\"" + embed + "\" title=\"" + html.EscapeString(attrs["title"]) + "\" frameborder=\"0\" allowfullscreen></iframe>\n</div>"
+}
+
+// An image with an optional caption
+func figureShortcode(attrs map[string]string) string {
+	output := "<figure"
+	if attrs["class"] != "" {
+		output += " class=\"" + html.EscapeString(attrs["class"]) + "\""
+	}
+	output += ">\n<img src=\"" + html.EscapeString(attrs["src"]) + "\" alt=\"" + html.EscapeString(attrs["alt"]) + "\">\n"
+	if attrs["caption"] != "" {
+		output += "<figcaption>" + html.EscapeString(attrs["caption"]) + "</figcaption>\n"
+	}
+	return output + "</figure>"
+}
+
+// A call to action link styled as a button
+func buttonShortcode(attrs map[string]string) string {
+	class := attrs["class"]
+	if class == "" {
+		class = "button"
+	}
+	return "<a class=\"" + html.EscapeString(class) + "\" href=\"" + html.EscapeString(attrs["href"]) + "\">" + html.EscapeString(attrs["text"]) + "</a>"
+}
+
+// Embeds a code file from the site directory as a fenced code block, rather than relying on a Gist
+func codeShortcode(attrs map[string]string) string {
+	file := relPath + projectDir + string(filepath.Separator) + filepath.FromSlash(attrs["file"])
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Println("Warning code shortcode file " + attrs["file"] + " could not be read")
+		return ""
+	}
+
+	lang := attrs["lang"]
+	if lang == "" {
+		lang = strings.TrimPrefix(filepath.Ext(file), ".")
+	}
+	return "\n```" + lang + "\n" + strings.TrimRight(string(content), "\n") + "\n```\n"
+}
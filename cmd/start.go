@@ -28,7 +28,6 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -62,7 +61,14 @@ func createMarkdownTemplate(template string, filename string, isPage bool) error
 	if !dirExist(themePath) {
 		log.Fatal("Error cannot find theme to create markdown templates")
 	}
-	if !dirExist(themePath + string(filepath.Separator) + template) {
+
+	// Template may be inherited from a parent theme
+	chain, err := themeChain(sitePath+string(filepath.Separator)+"theme", theme)
+	if err != nil {
+		return err
+	}
+	templatePath, found := findThemeFile(chain, template)
+	if !found {
 		log.Fatal("Error cannot find specified theme template")
 	}
 
@@ -71,51 +77,18 @@ func createMarkdownTemplate(template string, filename string, isPage bool) error
 		var fileOutput string
 
 		// Open template file & get contents
-		temp, err := ioutil.ReadFile(themePath + string(filepath.Separator) + template)
+		temp, err := ioutil.ReadFile(templatePath)
 		if err != nil {
 			return err
 		}
 
 		// Is it a page or a partial template?
 		if isPage {
-			// Parse meta with regex
-			var metaToken = regexp.MustCompile(`\[\[meta\sname\=\"([a-zA-Z0-9_-]*)\"]]`)
-			metaTokens := metaToken.FindAllStringSubmatch(string(temp), -1)
-
-			// Compose meta output
-
-			fileOutput += "+++\n\n"
-			fileOutput += "[Meta]\n"
-			for i := range metaTokens {
-				fileOutput += metaTokens[i][1] + " = \"\"\n"
-			}
-
-			// Add navigation tokens
-			fileOutput += "\n[Navigation]\n"
-			fileOutput += "text = \"\"\n"
-			fileOutput += "order = \"99\"\n"
-
-			// Add design tokens
-			fileOutput += "\n[Design]\n"
-			fileOutput += "template = \"" + strings.Replace(template, ".html", "", -1) + "\"\n"
-			fileOutput += "\n+++\n\n"
+			fileOutput += scaffoldFrontMatter(string(temp), pageScaffold{Template: strings.Replace(template, ".html", "", -1)})
 		}
 
-		// Parse element with regex
-		var elementToken = regexp.MustCompile(`\[\[element\stype\=\"([a-zA-Z0-9]*)\"\sname\=\"([a-zA-Z0-9_-]*)\"\sdescription\=\"(.*)"]]`)
-		elementTokens := elementToken.FindAllStringSubmatch(string(temp), -1)
-
 		// Compose element output
-		for i := range elementTokens {
-			fileOutput += "***" + strings.ToUpper(elementTokens[i][1]) + "*** " + strings.Title(elementTokens[i][2]) + " (" + elementTokens[i][3] + ")\n\n"
-			if elementTokens[i][1] == "html" {
-				fileOutput += "# Your " + strings.Title(elementTokens[i][2]) + " markdown/html syntax here\n\n"
-			} else {
-				fileOutput += "Your " + strings.Title(elementTokens[i][2]) + " text syntax here\n\n"
-			}
-
-			fileOutput += "***\n\n\n\n\n"
-		}
+		fileOutput += scaffoldElements(string(temp), nil)
 
 		// Write to file
 		err = writeFile(sitePath+string(filepath.Separator)+filename, fileOutput)
@@ -147,20 +120,27 @@ func scaffoldSite() error {
 		}
 	}
 
-	// Copy selected theme into sites theme folder
-	src := basePath + string(filepath.Separator) + "themes" + string(filepath.Separator) + theme
-	dest := basePath + string(filepath.Separator) + "sites" + string(filepath.Separator) + domain + string(filepath.Separator) + "theme" + string(filepath.Separator) + theme
+	// Copy selected theme, and any themes it inherits from, into sites theme folder
+	chain, err := themeChain(basePath+string(filepath.Separator)+"themes", theme)
+	if err != nil {
+		return err
+	}
+	sitePath := basePath + string(filepath.Separator) + "sites" + string(filepath.Separator) + domain
+	siteThemePath := sitePath + string(filepath.Separator) + "theme"
+	for _, src := range chain {
+		dest := siteThemePath + string(filepath.Separator) + filepath.Base(src)
 
-	// Check not exist before copy
-	if !dirExist(dest) {
-		err := copyDir(src, dest)
-		if err != nil {
-			return err
+		// Check not exist before copy
+		if !dirExist(dest) {
+			err := copyDir(src, dest)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
 	// Create config.toml file - domain, theme, https, pretty
-	err := createConfigToml()
+	err = createConfigToml()
 	if err != nil {
 		return err
 	}
@@ -171,9 +151,13 @@ func scaffoldSite() error {
 		return err
 	}
 
-	// List partials, run createMarkdownTemplate() for each
-	partialsDir := dest + string(filepath.Separator) + "partials"
-	if dirExist(partialsDir) {
+	// List partials, run createMarkdownTemplate() for each. Partials may come from any theme in the chain
+	for _, src := range chain {
+		partialsDir := siteThemePath + string(filepath.Separator) + filepath.Base(src) + string(filepath.Separator) + "partials"
+		if !dirExist(partialsDir) {
+			continue
+		}
+
 		// Create a site partials directory
 		if !dirExist(basePath + "sites" + string(filepath.Separator) + domain + string(filepath.Separator) + "partials") {
 			err := os.Mkdir(basePath+"sites"+string(filepath.Separator)+domain+string(filepath.Separator)+"partials", 0755)
@@ -183,11 +167,25 @@ func scaffoldSite() error {
 		}
 		err := filepath.Walk(partialsDir, func(path string, f os.FileInfo, _ error) error {
 			if !f.IsDir() {
-				if strings.ToLower(strings.Split(f.Name(), ".")[1]) == "html" {
-					tempFile := "partials" + string(filepath.Separator) + f.Name()
-					mdFile := "partials" + string(filepath.Separator) + strings.Replace(f.Name(), ".html", "", -1) + ".md"
+				if strings.ToLower(filepath.Ext(f.Name())) == ".html" {
+					// Partials may be in subdirectories
+					rel, err := filepath.Rel(partialsDir, path)
+					if err != nil {
+						return err
+					}
+					// Navigation templates are used by build, not included in pages
+					if isNavPartial(rel) {
+						return nil
+					}
 
-					err := createMarkdownTemplate(tempFile, mdFile, false)
+					tempFile := "partials" + string(filepath.Separator) + rel
+					mdFile := "partials" + string(filepath.Separator) + strings.Replace(rel, ".html", "", -1) + ".md"
+
+					err = os.MkdirAll(sitePath+string(filepath.Separator)+filepath.Dir(mdFile), 0755)
+					if err != nil {
+						return err
+					}
+					err = createMarkdownTemplate(tempFile, mdFile, false)
 					if err != nil {
 						return err
 					}
@@ -0,0 +1,306 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains 'facil page sync', which brings existing pages up to date with their templates
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/cobra"
+)
+
+var syncDryRun, syncPrune bool
+
+// Returns the start and end of the front matter, between the +++ markers, or -1 if there is none
+func frontMatterBounds(markdown string) (int, int) {
+	start := strings.Index(markdown, "+++")
+	if start == -1 {
+		return -1, -1
+	}
+	end := strings.Index(markdown[start+3:], "+++")
+	if end == -1 {
+		return -1, -1
+	}
+	return start + 3, start + 3 + end
+}
+
+// Returns the keys in the [Meta] table of front matter, and the line the table ends on
+func frontMatterMeta(lines []string) ([]string, int) {
+	var keys []string
+	inMeta := false
+	end := -1
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			inMeta = trimmed == "[Meta]"
+			if inMeta {
+				end = i + 1
+			}
+			continue
+		}
+		if inMeta && strings.Contains(trimmed, "=") {
+			keys = append(keys, strings.TrimSpace(strings.SplitN(trimmed, "=", 2)[0]))
+			end = i + 1
+		}
+	}
+	return keys, end
+}
+
+// Removes a key from the [Meta] table of front matter
+func removeMetaKey(lines []string, key string) []string {
+	var kept []string
+	inMeta := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "[") {
+			inMeta = trimmed == "[Meta]"
+		} else if inMeta && strings.Contains(trimmed, "=") && strings.TrimSpace(strings.SplitN(trimmed, "=", 2)[0]) == key {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return kept
+}
+
+// Brings a page up to date with its template. Returns the updated page and notes on what was,
+// or with --prune would be, changed
+func syncPage(markdown string, template string) (string, []string) {
+	var notes []string
+
+	start, end := frontMatterBounds(markdown)
+	if start == -1 {
+		return markdown, notes
+	}
+
+	// Meta, missing keys are added to the end of the [Meta] table
+	lines := strings.Split(markdown[start:end], "\n")
+	keys, metaEnd := frontMatterMeta(lines)
+	has := make(map[string]bool)
+	for _, key := range keys {
+		has[key] = true
+	}
+
+	var missing []string
+	for _, name := range templateMeta(template) {
+		if !has[name] {
+			missing = append(missing, name+" = \"\"")
+			notes = append(notes, "added meta "+name)
+		}
+		has[name] = false
+	}
+	if len(missing) > 0 {
+		if metaEnd == -1 {
+			// No [Meta] table, add one at the start
+			missing = append([]string{"", "[Meta]"}, missing...)
+			metaEnd = 1
+		}
+		lines = append(lines[:metaEnd], append(missing, lines[metaEnd:]...)...)
+	}
+
+	// Keys left over aren't used by the template
+	for _, key := range keys {
+		if has[key] {
+			if syncPrune {
+				lines = removeMetaKey(lines, key)
+				notes = append(notes, "removed meta "+key)
+			} else {
+				notes = append(notes, "meta "+key+" is not in the template")
+			}
+		}
+	}
+
+	frontMatter := strings.Join(lines, "\n")
+	body := markdown[end:]
+
+	// Elements, names are compared as build does. Overrides of partial elements are left alone
+	var markdownToken = regexp.MustCompile(`\*\*\*([a-zA-Z0-9]*)\*\*\*\s([a-zA-Z0-9_./-]*)\s.*\n([\d\D][^\*]*)\*\*\*\n*`)
+	found := make(map[string]bool)
+	body = markdownToken.ReplaceAllStringFunc(body, func(block string) string {
+		name := strings.ToLower(markdownToken.FindStringSubmatch(block)[2])
+		found[name] = true
+		if strings.Contains(name, ".") {
+			return block
+		}
+		for _, element := range templateElements(template) {
+			if strings.ToLower(element.Name) == name {
+				return block
+			}
+		}
+		if syncPrune {
+			notes = append(notes, "removed element "+name)
+			return ""
+		}
+		notes = append(notes, "element "+name+" is not in the template")
+		return block
+	})
+
+	// Missing elements are scaffolded at the end of the page
+	for _, element := range templateElements(template) {
+		if !found[strings.ToLower(element.Name)] {
+			if !strings.HasSuffix(body, "\n\n") {
+				body = strings.TrimRight(body, "\n") + "\n\n"
+			}
+			body += strings.TrimRight(scaffoldElement(element, ""), "\n") + "\n\n"
+			notes = append(notes, "added element "+element.Name)
+		}
+	}
+
+	return markdown[:start] + frontMatter + body, notes
+}
+
+// Returns a simple line by line diff of two versions of a file
+func lineDiff(a string, b string) string {
+	x := strings.Split(a, "\n")
+	y := strings.Split(b, "\n")
+
+	// Longest common subsequence of lines
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff string
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			i++
+			j++
+		case j < len(y) && (i == len(x) || lcs[i][j+1] >= lcs[i+1][j]):
+			diff += strconv.Itoa(j+1) + " + " + y[j] + "\n"
+			j++
+		default:
+			diff += strconv.Itoa(i+1) + " - " + x[i] + "\n"
+			i++
+		}
+	}
+	return diff
+}
+
+// Syncs every page, and error page, in the site with its template
+func syncPages() error {
+	loadConfig()
+
+	sitePath := relPath + projectDir + string(filepath.Separator)
+	for _, dir := range []string{"pages", "errors"} {
+		if !dirExist(sitePath + dir) {
+			continue
+		}
+
+		err := filepath.Walk(sitePath+dir, func(path string, f os.FileInfo, err error) error {
+			if err != nil || f.IsDir() || strings.ToLower(filepath.Ext(path)) != ".md" {
+				return err
+			}
+			rel, _ := filepath.Rel(sitePath, path)
+
+			markdown, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			// The page's template
+			start, end := frontMatterBounds(string(markdown))
+			if start == -1 {
+				fmt.Println(rel + ": no front matter, skipped")
+				return nil
+			}
+			var pc pageConfig
+			if _, err := toml.Decode(string(markdown[start:end]), &pc); err != nil {
+				fmt.Println(rel + ": front matter could not be read, skipped")
+				return nil
+			}
+			templatePath, found := findThemeFile(themeDirs, pc.Design.Template+".html")
+			if !found {
+				fmt.Println(rel + ": template " + pc.Design.Template + " not found, skipped")
+				return nil
+			}
+			template, err := ioutil.ReadFile(templatePath)
+			if err != nil {
+				return err
+			}
+
+			updated, notes := syncPage(string(markdown), string(template))
+			for _, note := range notes {
+				fmt.Println(rel + ": " + note)
+			}
+			if updated == string(markdown) {
+				return nil
+			}
+
+			if syncDryRun {
+				fmt.Println("--- " + rel)
+				fmt.Print(lineDiff(string(markdown), updated))
+				return nil
+			}
+			return writeFile(path, updated)
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncCmd represents the page sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Updates existing pages to match their templates",
+	Long: `Updates existing pages after their theme templates change. Elements and meta values added to
+	a template are scaffolded into the pages using it, existing content is left as it is.
+
+	Elements and meta values no longer in the template are reported, use --prune to remove them.
+	Uses --dry-run flag to show the changes without making them.
+	`,
+	Run: func(cmd *cobra.Command, args []string) {
+		project = strings.Join(args, " ")
+		err := syncPages()
+		if err != nil {
+			log.Fatal("Error unable to sync pages")
+		}
+	},
+}
+
+func init() {
+	pageCmd.AddCommand(syncCmd)
+	syncCmd.Flags().BoolVarP(&syncDryRun, "dry-run", "", false, "Show the changes without making them")
+	syncCmd.Flags().BoolVarP(&syncPrune, "prune", "", false, "Remove elements and meta values which are no longer in the template")
+}
@@ -0,0 +1,627 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains the functionality behind 'facil theme', which lists, installs, packs and
+// describes the themes in the themes directory. Themes describe themselves in a theme.toml manifest.
+
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/cobra"
+)
+
+type themeManifest struct {
+	Name        string
+	Version     string
+	Author      string
+	Description string
+	Templates   []string
+	Facil       string
+	Parent      string
+	Navigation  themeNavigation
+}
+
+// Class names a theme gives the current page's link, and the items leading to it, in navigation
+type themeNavigation struct {
+	Active string
+	Trail  string
+}
+
+var (
+	forceInstall bool
+	packFormat   string
+	packOutput   string
+)
+
+// Reads theme.toml from a theme directory, themes without one get a manifest named after the directory
+func readThemeManifest(themePath string) (themeManifest, error) {
+	manifest := themeManifest{Name: filepath.Base(themePath)}
+	manifestPath := themePath + string(filepath.Separator) + "theme.toml"
+	if !dirExist(manifestPath) {
+		return manifest, nil
+	}
+
+	tomlData, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return manifest, err
+	}
+	_, err = toml.Decode(string(tomlData), &manifest)
+	return manifest, err
+}
+
+// Returns the name a theme's theme.toml gives it, empty if it has none. Unlike readThemeManifest
+// this doesn't fall back to the directory name, which for an unpacked archive is a temporary one
+func manifestName(themePath string) string {
+	var manifest struct{ Name string }
+	tomlData, err := ioutil.ReadFile(themePath + string(filepath.Separator) + "theme.toml")
+	if err != nil {
+		return ""
+	}
+	if _, err := toml.Decode(string(tomlData), &manifest); err != nil {
+		return ""
+	}
+	return manifest.Name
+}
+
+// Returns the directories of a theme and the themes it inherits from, child first. Themes are looked
+// up in themesPath, which is either the themes directory or a site's theme directory
+func themeChain(themesPath string, name string) ([]string, error) {
+	var chain []string
+	seen := make(map[string]bool)
+	for name != "" {
+		if seen[name] {
+			return chain, fmt.Errorf("Error theme %s inherits from itself", name)
+		}
+		seen[name] = true
+
+		themePath := themesPath + string(filepath.Separator) + name
+		if !dirExist(themePath) {
+			return chain, fmt.Errorf("Error theme %s not found", name)
+		}
+		chain = append(chain, themePath)
+
+		manifest, err := readThemeManifest(themePath)
+		if err != nil {
+			return chain, err
+		}
+		name = manifest.Parent
+	}
+	return chain, nil
+}
+
+// Finds a file in the first theme of the chain which has it, so child themes override parent themes
+func findThemeFile(chain []string, rel string) (string, bool) {
+	for _, themePath := range chain {
+		path := themePath + string(filepath.Separator) + rel
+		if dirExist(path) {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Compares two dotted version numbers, returning -1, 0 or 1
+func compareVersions(a string, b string) int {
+	first := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	second := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(first) || i < len(second); i++ {
+		var x, y int
+		if i < len(first) {
+			x, _ = strconv.Atoi(first[i])
+		}
+		if i < len(second) {
+			y, _ = strconv.Atoi(second[i])
+		}
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Checks a theme is complete and supported by this version of Facil. Templates may be inherited
+// from a parent theme, which must already be installed
+func validateTheme(themePath string, manifest themeManifest) error {
+	chain := []string{themePath}
+	if manifest.Parent != "" {
+		parents, err := themeChain(basePath+"themes", manifest.Parent)
+		if err != nil {
+			return fmt.Errorf("Error theme %s needs parent theme %s installed first", manifest.Name, manifest.Parent)
+		}
+		chain = append(chain, parents...)
+	}
+
+	if _, found := findThemeFile(chain, "default.html"); !found {
+		return fmt.Errorf("Error theme %s has no default.html template", manifest.Name)
+	}
+	for _, template := range manifest.Templates {
+		if _, found := findThemeFile(chain, template+".html"); !found {
+			return fmt.Errorf("Error theme %s is missing template %s.html", manifest.Name, template)
+		}
+	}
+	required := strings.TrimSpace(strings.TrimPrefix(manifest.Facil, ">="))
+	if required != "" && compareVersions(Version, required) < 0 {
+		return fmt.Errorf("Error theme %s requires Facil %s or later", manifest.Name, required)
+	}
+	return nil
+}
+
+// Lists the html templates and partials found in a theme directory
+func themeTemplates(themePath string, dir string) []string {
+	var templates []string
+	files, _ := ioutil.ReadDir(themePath + string(filepath.Separator) + dir)
+	for _, f := range files {
+		if !f.IsDir() && strings.ToLower(filepath.Ext(f.Name())) == ".html" {
+			templates = append(templates, strings.TrimSuffix(f.Name(), filepath.Ext(f.Name())))
+		}
+	}
+	return templates
+}
+
+func listThemes() error {
+	themesPath := basePath + "themes"
+	dirs, err := ioutil.ReadDir(themesPath)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		manifest, err := readThemeManifest(themesPath + string(filepath.Separator) + dir.Name())
+		if err != nil {
+			fmt.Println(dir.Name() + " (theme.toml could not be read)")
+			continue
+		}
+
+		line := dir.Name()
+		if manifest.Version != "" {
+			line += " " + manifest.Version
+		}
+		if manifest.Author != "" {
+			line += " by " + manifest.Author
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func themeInfo(name string) error {
+	themePath := basePath + "themes" + string(filepath.Separator) + name
+	if !dirExist(themePath) {
+		return fmt.Errorf("Error theme %s not found", name)
+	}
+	manifest, err := readThemeManifest(themePath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Name:        " + manifest.Name)
+	fmt.Println("Version:     " + manifest.Version)
+	fmt.Println("Author:      " + manifest.Author)
+	fmt.Println("Description: " + manifest.Description)
+	fmt.Println("Requires:    Facil " + manifest.Facil)
+	if manifest.Parent != "" {
+		fmt.Println("Parent:      " + manifest.Parent)
+	}
+	fmt.Println("Templates:   " + strings.Join(themeTemplates(themePath, ""), ", "))
+	fmt.Println("Partials:    " + strings.Join(themeTemplates(themePath, "partials"), ", "))
+
+	if err := validateTheme(themePath, manifest); err != nil {
+		fmt.Println("Warning:     " + strings.TrimPrefix(err.Error(), "Error "))
+	}
+	return nil
+}
+
+// Makes sure an archive entry stays within the directory it is extracted to
+func archivePath(dest string, name string) (string, error) {
+	path := filepath.Join(dest, filepath.FromSlash(name))
+	if path != filepath.Clean(dest) && !strings.HasPrefix(path, filepath.Clean(dest)+string(filepath.Separator)) {
+		return "", fmt.Errorf("Error archive entry %s is outside the theme", name)
+	}
+	return path, nil
+}
+
+func extractZip(source string, dest string) error {
+	reader, err := zip.OpenReader(source)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for _, f := range reader.File {
+		path, err := archivePath(dest, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			os.MkdirAll(path, 0755)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		src, err := f.Open()
+		if err != nil {
+			return err
+		}
+		destfile, err := os.Create(path)
+		if err != nil {
+			src.Close()
+			return err
+		}
+		_, err = io.Copy(destfile, src)
+		src.Close()
+		destfile.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTarGz(source string, dest string) error {
+	file, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		path, err := archivePath(dest, header.Name)
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			os.MkdirAll(path, 0755)
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			destfile, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(destfile, reader)
+			destfile.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Archives often wrap the theme in a single directory, find the directory holding the templates
+func findThemeRoot(dir string) string {
+	if dirExist(dir+string(filepath.Separator)+"default.html") || dirExist(dir+string(filepath.Separator)+"theme.toml") {
+		return dir
+	}
+	objects, _ := ioutil.ReadDir(dir)
+	if len(objects) == 1 && objects[0].IsDir() {
+		return findThemeRoot(dir + string(filepath.Separator) + objects[0].Name())
+	}
+	return dir
+}
+
+// Checks a theme name is a single directory name, it becomes the theme's directory in themes
+func validThemeName(name string) error {
+	if name == "" || strings.Contains(name, "..") || strings.ContainsAny(name, `/\`) || filepath.IsAbs(name) || name != filepath.Base(name) {
+		return fmt.Errorf("Error invalid theme name %q", name)
+	}
+	return nil
+}
+
+func installTheme(source string) error {
+	source = strings.TrimRight(source, string(filepath.Separator))
+	if !dirExist(source) {
+		return fmt.Errorf("Error theme source %s not found", source)
+	}
+
+	// Unpack archives to a temporary directory
+	var themePath, name string
+	lower := strings.ToLower(source)
+	switch {
+	case strings.HasSuffix(lower, ".zip"), strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		tmp, err := ioutil.TempDir("", "facil-theme")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmp)
+
+		if strings.HasSuffix(lower, ".zip") {
+			err = extractZip(source, tmp)
+		} else {
+			err = extractTarGz(source, tmp)
+		}
+		if err != nil {
+			return err
+		}
+		themePath = findThemeRoot(tmp)
+
+		name = filepath.Base(source)
+		for _, ext := range []string{".zip", ".tar.gz", ".tgz"} {
+			if strings.HasSuffix(strings.ToLower(name), ext) {
+				name = name[:len(name)-len(ext)]
+			}
+		}
+	default:
+		themePath = source
+		name = filepath.Base(source)
+	}
+
+	manifest, err := readThemeManifest(themePath)
+	if err != nil {
+		return err
+	}
+	if explicit := manifestName(themePath); explicit != "" {
+		name = explicit
+	}
+	manifest.Name = name
+
+	err = validateTheme(themePath, manifest)
+	if err != nil {
+		return err
+	}
+
+	// The name may come from the theme's manifest, so make sure it stays within themes
+	err = validThemeName(name)
+	if err != nil {
+		return err
+	}
+	themesPath := filepath.Clean(basePath + "themes")
+	dest := filepath.Clean(themesPath + string(filepath.Separator) + name)
+	if !strings.HasPrefix(dest, themesPath+string(filepath.Separator)) {
+		return fmt.Errorf("Error theme name %s is outside the themes directory", name)
+	}
+
+	if dirExist(dest) {
+		if !forceInstall {
+			return fmt.Errorf("Error theme %s is already installed, use --force to replace it", name)
+		}
+		err = os.RemoveAll(dest)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = copyDir(themePath, dest)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Installed theme " + name)
+	return nil
+}
+
+func packTheme(name string) error {
+	themePath := basePath + "themes" + string(filepath.Separator) + name
+	if !dirExist(themePath) {
+		return fmt.Errorf("Error theme %s not found", name)
+	}
+	manifest, err := readThemeManifest(themePath)
+	if err != nil {
+		return err
+	}
+	err = validateTheme(themePath, manifest)
+	if err != nil {
+		return err
+	}
+
+	output := packOutput
+	if output == "" {
+		output = name
+		if manifest.Version != "" {
+			output += "-" + manifest.Version
+		}
+		if packFormat == "zip" {
+			output += ".zip"
+		} else {
+			output += ".tar.gz"
+		}
+	}
+
+	file, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Entries are stored under a directory named after the theme
+	var add func(path string, archiveName string, f os.FileInfo) error
+	var finish func() error
+
+	if packFormat == "zip" {
+		writer := zip.NewWriter(file)
+		add = func(path string, archiveName string, f os.FileInfo) error {
+			entry, err := writer.Create(archiveName)
+			if err != nil {
+				return err
+			}
+			src, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+			_, err = io.Copy(entry, src)
+			return err
+		}
+		finish = writer.Close
+	} else {
+		gz := gzip.NewWriter(file)
+		writer := tar.NewWriter(gz)
+		add = func(path string, archiveName string, f os.FileInfo) error {
+			header, err := tar.FileInfoHeader(f, "")
+			if err != nil {
+				return err
+			}
+			header.Name = archiveName
+			err = writer.WriteHeader(header)
+			if err != nil {
+				return err
+			}
+			src, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+			_, err = io.Copy(writer, src)
+			return err
+		}
+		finish = func() error {
+			if err := writer.Close(); err != nil {
+				return err
+			}
+			return gz.Close()
+		}
+	}
+
+	err = filepath.Walk(themePath, func(path string, f os.FileInfo, err error) error {
+		if err != nil || f.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(themePath, path)
+		if err != nil {
+			return err
+		}
+		return add(path, name+"/"+filepath.ToSlash(rel), f)
+	})
+	if err != nil {
+		return err
+	}
+
+	err = finish()
+	if err != nil {
+		return err
+	}
+	fmt.Println("Packed theme " + name + " to " + output)
+	return nil
+}
+
+// themeCmd represents the theme command
+var themeCmd = &cobra.Command{
+	Use:   "theme",
+	Short: "Manages themes",
+	Long: `Lists, installs, packs and describes the themes in the themes directory.
+
+    Themes describe themselves in a theme.toml manifest, declaring name, version, author, templates
+    and the minimum version of Facil they require.
+    `,
+}
+
+var themeListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists installed themes",
+	Run: func(cmd *cobra.Command, args []string) {
+		setBasePath()
+		err := listThemes()
+		if err != nil {
+			log.Fatal("Error could not read themes directory")
+		}
+	},
+}
+
+var themeInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Installs a theme from a directory, .zip or .tar.gz",
+	Long: `Installs a theme from a local directory, .zip or .tar.gz archive into the themes directory.
+
+    Uses --force flag to replace a theme that is already installed.
+    `,
+	Run: func(cmd *cobra.Command, args []string) {
+		setBasePath()
+		err := installTheme(strings.Join(args, " "))
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var themePackCmd = &cobra.Command{
+	Use:   "pack",
+	Short: "Packs a theme into an archive",
+	Long: `Packs a theme from the themes directory into a .tar.gz archive, ready to share.
+
+    Uses --format flag to create a .zip archive instead and --output to name the archive.
+    `,
+	Run: func(cmd *cobra.Command, args []string) {
+		setBasePath()
+		err := packTheme(strings.Join(args, " "))
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+var themeInfoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Describes a theme",
+	Run: func(cmd *cobra.Command, args []string) {
+		setBasePath()
+		err := themeInfo(strings.Join(args, " "))
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(themeCmd)
+	themeCmd.AddCommand(themeListCmd)
+	themeCmd.AddCommand(themeInstallCmd)
+	themeCmd.AddCommand(themePackCmd)
+	themeCmd.AddCommand(themeInfoCmd)
+	themeInstallCmd.Flags().BoolVarP(&forceInstall, "force", "", false, "Replace the theme if already installed")
+	themePackCmd.Flags().StringVarP(&packFormat, "format", "", "tar.gz", "Archive format, tar.gz or zip")
+	themePackCmd.Flags().StringVarP(&packOutput, "output", "", "", "Archive file to write")
+}
@@ -0,0 +1,149 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains heading anchors and the [[toc]] token. Headings in html elements are given
+// stable ids, from which a nested table of contents can be rendered.
+
+package cmd
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type heading struct {
+	Level int
+	ID    string
+	Text  string
+}
+
+var (
+	headingToken = regexp.MustCompile(`(?s)<h([1-6])([^>]*)>(.*?)</h[1-6]>`)
+	headingID    = regexp.MustCompile(`\sid="([^"]*)"`)
+	tocToken     = regexp.MustCompile(`\[\[toc((?:\s+[a-zA-Z0-9_-]+="[^"]*")*)\s*]]`)
+)
+
+// Gives each heading without one an id based on its text, unique within the page. Returns the
+// html and the headings found
+func anchorHeadings(content string, ids map[string]bool) (string, []heading) {
+	var headings []heading
+	permalinks := optionOn(conf.Markdown.Permalinks, false)
+
+	content = headingToken.ReplaceAllStringFunc(content, func(token string) string {
+		match := headingToken.FindStringSubmatch(token)
+		level, _ := strconv.Atoi(match[1])
+		attrs, inner := match[2], match[3]
+		// Text is unescaped, escape it again wherever it's written as html
+		text := stripHTML(inner)
+
+		// Keep ids given in markdown, otherwise create one
+		var id string
+		if existing := headingID.FindStringSubmatch(attrs); existing != nil {
+			id = existing[1]
+		} else {
+			id = slugify(text)
+			if id == "" {
+				id = "section"
+			}
+			unique := id
+			for n := 1; ids[unique]; n++ {
+				unique = id + "-" + strconv.Itoa(n)
+			}
+			id = unique
+			attrs = " id=\"" + id + "\"" + attrs
+		}
+		ids[id] = true
+		headings = append(headings, heading{Level: level, ID: id, Text: text})
+
+		if permalinks {
+			inner += " <a class=\"permalink\" href=\"#" + id + "\" aria-hidden=\"true\">¶</a>"
+		}
+		return "<h" + match[1] + attrs + ">" + inner + "</h" + match[1] + ">"
+	})
+	return content, headings
+}
+
+// Renders headings as nested lists
+func tocList(headings []heading) string {
+	if len(headings) == 0 {
+		return ""
+	}
+
+	list := "<ul class=\"toc\">\n"
+	levels := []int{headings[0].Level}
+	for i, h := range headings {
+		if i > 0 {
+			if h.Level > levels[len(levels)-1] {
+				// Open a nested list within the previous item
+				levels = append(levels, h.Level)
+				list += "\n" + strings.Repeat("\t", len(levels)*2-2) + "<ul>\n"
+			} else {
+				list += "</li>\n"
+				for len(levels) > 1 && h.Level < levels[len(levels)-1] {
+					levels = levels[:len(levels)-1]
+					list += strings.Repeat("\t", len(levels)*2) + "</ul>\n" + strings.Repeat("\t", len(levels)*2-1) + "</li>\n"
+				}
+			}
+		}
+		list += strings.Repeat("\t", len(levels)*2-1) + "<li><a href=\"#" + h.ID + "\">" + html.EscapeString(h.Text) + "</a>"
+	}
+	list += "</li>\n"
+	for len(levels) > 1 {
+		levels = levels[:len(levels)-1]
+		list += strings.Repeat("\t", len(levels)*2) + "</ul>\n" + strings.Repeat("\t", len(levels)*2-1) + "</li>\n"
+	}
+	return list + "</ul>"
+}
+
+// Replaces [[toc]] tokens. The element attribute limits the contents to one element, depth limits
+// the levels of headings included, counting from the highest level heading
+func processToc(template string, elements []pageElement) string {
+	return tocToken.ReplaceAllStringFunc(template, func(token string) string {
+		attrs := tokenAttributes(tocToken.FindStringSubmatch(token)[1])
+		depth, err := strconv.Atoi(attrs["depth"])
+		if err != nil || depth < 1 {
+			depth = 3
+		}
+
+		var headings []heading
+		top := 6
+		for _, el := range elements {
+			if attrs["element"] != "" && el.Name != strings.ToLower(attrs["element"]) {
+				continue
+			}
+			for _, h := range el.Headings {
+				headings = append(headings, h)
+				if h.Level < top {
+					top = h.Level
+				}
+			}
+		}
+
+		var included []heading
+		for _, h := range headings {
+			if h.Level < top+depth {
+				included = append(included, h)
+			}
+		}
+		return tocList(included)
+	})
+}
@@ -21,12 +21,14 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -50,6 +52,14 @@ func writeFile(filename string, content string) error {
 	return nil
 }
 
+func writeJSON(filename string, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFile(filename, string(content))
+}
+
 func dirExist(path string) bool {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return false
@@ -140,3 +150,21 @@ func upperFirst(s string) string {
 	r, n := utf8.DecodeRuneInString(s)
 	return string(unicode.ToUpper(r)) + s[n:]
 }
+
+// Converts text to a lowercase, hyphenated form for use in ids and file names
+func slugify(s string) string {
+	var slug []rune
+	hyphen := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			if hyphen && len(slug) > 0 {
+				slug = append(slug, '-')
+			}
+			slug = append(slug, r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+	return string(slug)
+}
@@ -0,0 +1,373 @@
+// Copyright © 2016 Ollie Phillips <ollie@interject.io>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// This file contains 'facil import --wordpress', which creates pages from a WordPress WXR export
+
+package cmd
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// WordPress export, only what's needed to create pages is read
+	wxrExport struct {
+		Items []wxrItem `xml:"channel>item"`
+	}
+
+	wxrItem struct {
+		Title         string        `xml:"title"`
+		Creator       string        `xml:"creator"`
+		Encoded       []wxrEncoded  `xml:"encoded"`
+		ID            int           `xml:"post_id"`
+		Date          string        `xml:"post_date"`
+		Name          string        `xml:"post_name"`
+		Status        string        `xml:"status"`
+		Parent        int           `xml:"post_parent"`
+		MenuOrder     int           `xml:"menu_order"`
+		Type          string        `xml:"post_type"`
+		AttachmentURL string        `xml:"attachment_url"`
+		Categories    []wxrCategory `xml:"category"`
+	}
+
+	// Post content and excerpt are both 'encoded', in different namespaces
+	wxrEncoded struct {
+		XMLName xml.Name
+		Value   string `xml:",chardata"`
+	}
+
+	wxrCategory struct {
+		Domain string `xml:"domain,attr"`
+		Name   string `xml:",chardata"`
+	}
+)
+
+var (
+	wordpressFile    string
+	wordpressElement string
+	wordpressUploads string
+)
+
+var (
+	uploadsURL     = regexp.MustCompile(`https?://[^"'\s()<>]*/wp-content/uploads/([^"'\s()<>?#]+)`)
+	paragraphTag   = regexp.MustCompile(`(?i)<p[\s>]`)
+	paragraphBreak = regexp.MustCompile(`\n\s*\n`)
+	preBlock       = regexp.MustCompile(`(?is)<pre[\s>].*?</pre>`)
+)
+
+func (item wxrItem) encoded(space string) string {
+	for _, e := range item.Encoded {
+		if strings.Contains(e.XMLName.Space, space) {
+			return e.Value
+		}
+	}
+	return ""
+}
+
+// Adds paragraphs to content written in the classic editor, which WordPress adds when displaying it.
+// Preformatted blocks are kept as they are
+func autoParagraph(content string) string {
+	if paragraphTag.MatchString(content) {
+		return content
+	}
+	content = strings.Replace(content, "\r\n", "\n", -1)
+
+	var blocks []string
+	last := 0
+	for _, pre := range preBlock.FindAllStringIndex(content, -1) {
+		blocks = append(blocks, paragraphs(content[last:pre[0]])...)
+		blocks = append(blocks, content[pre[0]:pre[1]])
+		last = pre[1]
+	}
+	blocks = append(blocks, paragraphs(content[last:])...)
+	return strings.Join(blocks, "\n")
+}
+
+// Wraps text separated by blank lines in paragraphs, single line breaks become <br>
+func paragraphs(text string) []string {
+	var wrapped []string
+	for _, p := range paragraphBreak.Split(text, -1) {
+		if strings.TrimSpace(p) != "" {
+			wrapped = append(wrapped, "<p>"+strings.Replace(strings.TrimSpace(p), "\n", "<br>", -1)+"</p>")
+		}
+	}
+	return wrapped
+}
+
+// Returns a description from the excerpt, or the start of the content if there is none
+func wordpressDescription(item wxrItem) string {
+	source := item.encoded("excerpt")
+	if strings.TrimSpace(source) == "" {
+		source = item.encoded("content")
+	}
+	nodes, err := htmlFragment(source)
+	if err != nil {
+		return ""
+	}
+	var text string
+	for _, n := range nodes {
+		text += " " + nodeText(n)
+	}
+	words := strings.Fields(text)
+	if len(words) > 30 {
+		return strings.Join(words[:30], " ") + "..."
+	}
+	return strings.Join(words, " ")
+}
+
+// Returns the page file for a WordPress page or post, pages are nested beneath their parent pages
+func wordpressPagePath(item wxrItem, byID map[int]wxrItem) string {
+	slug := func(i wxrItem) string {
+		if i.Name != "" {
+			return slugify(i.Name)
+		}
+		if s := slugify(i.Title); s != "" {
+			return s
+		}
+		return strconv.Itoa(i.ID)
+	}
+
+	if item.Type == "post" {
+		return "blog" + string(filepath.Separator) + slug(item) + ".md"
+	}
+
+	parts := []string{slug(item)}
+	seen := map[int]bool{item.ID: true}
+	for parent, ok := byID[item.Parent]; ok && !seen[parent.ID]; parent, ok = byID[parent.Parent] {
+		seen[parent.ID] = true
+		parts = append([]string{slug(parent)}, parts...)
+	}
+	return strings.Join(parts, string(filepath.Separator)) + ".md"
+}
+
+// Returns where an upload is found within a directory, or false if its path would leave it
+func uploadPath(root string, rel string) (string, bool) {
+	rel = path.Clean(rel)
+	if path.IsAbs(rel) || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	p, err := archivePath(root, rel)
+	if err != nil {
+		return "", false
+	}
+	return p, true
+}
+
+// Copies an upload into the theme, from a local copy of wp-content/uploads if there is one,
+// otherwise it is downloaded
+func copyUpload(client *http.Client, link string, rel string, dest string) error {
+	if dirExist(dest) {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+
+	if wordpressUploads != "" {
+		source, ok := uploadPath(wordpressUploads, rel)
+		if !ok {
+			return fmt.Errorf("%s is outside %s", rel, wordpressUploads)
+		}
+		return copyFile(source, dest)
+	}
+
+	resp, err := client.Get(link)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", link, resp.StatusCode)
+	}
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+func importWordPress(filename string) error {
+	loadConfig()
+
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Fatal("Error cannot open WordPress export " + filename)
+	}
+	defer f.Close()
+
+	var export wxrExport
+	if err := xml.NewDecoder(f).Decode(&export); err != nil {
+		log.Fatal("Error cannot parse WordPress export " + filename)
+	}
+
+	// Template and the element content goes in, the first html element unless one is chosen
+	templatePath, found := findThemeFile(themeDirs, template+".html")
+	if !found {
+		log.Fatal("Error cannot find specified theme template")
+	}
+	temp, err := ioutil.ReadFile(templatePath)
+	if err != nil {
+		return err
+	}
+	element := wordpressElement
+	hasTitle := false
+	for _, e := range templateElements(string(temp)) {
+		if element == "" && e.Type == "html" {
+			element = e.Name
+		}
+		if e.Name == "title" && e.Type == "text" {
+			hasTitle = true
+		}
+	}
+	if element == "" {
+		log.Fatal("Error template has no html element for the content, choose one with --element")
+	}
+
+	sitePath := relPath + projectDir + string(filepath.Separator)
+	pagesPath := sitePath + "pages"
+	blog := dirExist(pagesPath+string(filepath.Separator)+"blog") || dirExist(pagesPath+string(filepath.Separator)+"blog.md")
+	uploadsPath := sitePath + "theme" + string(filepath.Separator) + conf.Theme + string(filepath.Separator) + "images" + string(filepath.Separator) + "uploads"
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	byID := make(map[int]wxrItem)
+	for _, item := range export.Items {
+		byID[item.ID] = item
+	}
+
+	// Uploads, attachments and any others used in content
+	uploads := make(map[string]string)
+	for _, item := range export.Items {
+		if item.Type == "attachment" && item.AttachmentURL != "" {
+			if m := uploadsURL.FindStringSubmatch(item.AttachmentURL); m != nil {
+				uploads[m[0]] = m[1]
+			}
+		}
+		for _, m := range uploadsURL.FindAllStringSubmatch(item.encoded("content"), -1) {
+			uploads[m[0]] = m[1]
+		}
+	}
+	// Only uploads copied are linked from the theme, those outside the uploads directory are refused
+	copied := make(map[string]string)
+	for link, rel := range uploads {
+		dest, ok := uploadPath(uploadsPath, rel)
+		if !ok {
+			log.Println("Warning upload " + rel + " is outside the uploads directory, skipped")
+			continue
+		}
+		err := copyUpload(client, link, rel, dest)
+		if err != nil {
+			log.Println("Warning upload " + rel + " could not be copied: " + err.Error())
+			continue
+		}
+		copied[link] = path.Clean(rel)
+	}
+
+	for _, item := range export.Items {
+		if item.Type != "page" && item.Type != "post" {
+			continue
+		}
+		if item.Type == "post" && !blog {
+			fmt.Println(item.Title + ": skipped, posts are only imported into a pages/blog section")
+			continue
+		}
+
+		values := pageScaffold{
+			Template: template,
+			Meta:     make(map[string]string),
+			Elements: make(map[string]string),
+			Hidden:   item.Type == "post",
+		}
+
+		switch item.Status {
+		case "publish":
+		case "draft", "pending", "private":
+			values.Draft = true
+		case "future":
+			values.Publish = strings.Replace(item.Date, " ", "T", 1)
+		default:
+			continue
+		}
+
+		if item.MenuOrder != 0 {
+			values.Order = strconv.Itoa(item.MenuOrder)
+		}
+
+		// Meta from the post
+		var keywords []string
+		for _, c := range item.Categories {
+			if c.Domain == "category" || c.Domain == "post_tag" {
+				keywords = append(keywords, c.Name)
+			}
+		}
+		values.Meta["title"] = item.Title
+		values.Meta["description"] = wordpressDescription(item)
+		values.Meta["author"] = item.Creator
+		values.Meta["keywords"] = strings.Join(keywords, ", ")
+
+		// Content, with uploads linked from the theme
+		content := uploadsURL.ReplaceAllStringFunc(item.encoded("content"), func(link string) string {
+			if rel, ok := copied[link]; ok {
+				return "/images/uploads/" + rel
+			}
+			return link
+		})
+		values.Elements[element], err = htmlStringToMarkdown(autoParagraph(content))
+		if err != nil {
+			return err
+		}
+		if hasTitle && element != "title" {
+			values.Elements["title"] = strings.Replace(item.Title, "*", "&#42;", -1)
+		}
+
+		rel := wordpressPagePath(item, byID)
+		dest := pagesPath + string(filepath.Separator) + rel
+		if dirExist(dest) && !importForce {
+			fmt.Println(item.Title + ": skipped, " + rel + " already exists")
+			continue
+		}
+
+		err = os.MkdirAll(filepath.Dir(dest), 0755)
+		if err != nil {
+			return err
+		}
+		err = writePageFile(dest, string(temp), values)
+		if err != nil {
+			return err
+		}
+		fmt.Println(item.Title + ": imported to pages" + string(filepath.Separator) + rel)
+	}
+	return nil
+}